@@ -0,0 +1,172 @@
+// Package ban watches the bans of accounts.
+package ban
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/notify"
+)
+
+const timeFormat = "2 Jan 2006 15:04"
+
+// warnBefore is how long before the end of a ban
+// the ban_expiring event is emitted.
+const warnBefore = 24 * time.Hour
+
+// Scheduler checks the bans of every account in regular intervals.
+type Scheduler struct {
+	DB     *db.DB
+	Events *notify.Dispatcher
+	// Interval defaults to 15 minutes.
+	Interval time.Duration
+	// Logger is used for failed checks, defaults to stderr.
+	Logger *log.Logger
+
+	once sync.Once
+}
+
+func (s *Scheduler) init() {
+	s.once.Do(func() {
+		if s.Interval <= 0 {
+			s.Interval = 15 * time.Minute
+		}
+		if s.Logger == nil {
+			s.Logger = log.New(os.Stderr, "ERROR ", log.LstdFlags)
+		}
+	})
+}
+
+// Run checks the bans until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.init()
+	for {
+		if err := s.Check(time.Now()); err != nil {
+			s.Logger.Printf("ban: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// Check clears expired bans and emits a ban_expiring event
+// for every ban that ends within a day after now, once per ban.
+// A failed account is logged and doesn't stop the others,
+// the returned error summarizes the failures.
+func (s *Scheduler) Check(now time.Time) error {
+	s.init()
+	accs, err := s.DB.Accounts()
+	if err != nil {
+		return fmt.Errorf("couldn't read accounts from database, %v", err)
+	}
+	checks, failed := 0, make([]string, 0)
+	for _, acc := range accs {
+		// The ban date of a permanently banned account doesn't end anything.
+		if !acc.Ban.Valid || acc.Perma {
+			continue
+		}
+		end := acc.Ban.Time
+		switch {
+		case !end.After(now):
+			err = s.expire(acc, now)
+		case end.Sub(now) <= warnBefore:
+			err = s.warn(acc, now)
+		default:
+			continue
+		}
+		checks++
+		if err != nil {
+			s.Logger.Printf("ban: %v", err)
+			failed = append(failed, strconv.Itoa(acc.ID))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d ban checks failed (Account-IDs: %s)", len(failed), checks, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// expire clears the ban of acc and keeps it in the history and penalties.
+func (s *Scheduler) expire(acc *db.Account, now time.Time) error {
+	end := acc.Ban.Time
+	cleared, err := s.DB.ClearBan(acc.ID, end)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't clear ban in database (Account-ID: %d), %v", acc.ID, err)
+	}
+	if !cleared {
+		// Changed by a user in the meantime.
+		return nil
+	}
+	acc.Ban = db.NullTime{}
+	if err := s.archive(acc.ID, end); err != nil {
+		return fmt.Errorf("couldn't archive ban (Account-ID: %d), %v", acc.ID, err)
+	}
+
+	e := &db.Event{
+		AccountID: acc.ID,
+		Time:      now,
+		Kind:      db.EventBanExpired,
+		Message:   "Ban until " + end.Local().Format(timeFormat) + " expired",
+	}
+	if err := s.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("couldn't add expired ban to history (Account-ID: %d), %v", acc.ID, err)
+	}
+	return nil
+}
+
+// archive adds a ban ending at end to the penalties,
+// unless it was recorded when it was set.
+func (s *Scheduler) archive(accountID int, end time.Time) error {
+	penalties, err := s.DB.Penalties(accountID)
+	if err != nil {
+		return err
+	}
+	for _, p := range penalties {
+		if p.Type == db.PenaltyTempBan && p.End.Valid && p.End.Time.Equal(end) {
+			return nil
+		}
+	}
+	return s.DB.AddPenalty(&db.Penalty{
+		AccountID: accountID,
+		Type:      db.PenaltyTempBan,
+		End:       db.NullTime{Time: end, Valid: true},
+	})
+}
+
+func (s *Scheduler) warn(acc *db.Account, now time.Time) error {
+	history, err := s.DB.Events(acc.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't read history (Account-ID: %d), %v", acc.ID, err)
+	}
+	since := acc.Ban.Time.Add(-warnBefore)
+	for _, e := range history {
+		if e.Kind == db.EventBanExpiring && !e.Time.Before(since) {
+			return nil
+		}
+	}
+
+	e := &db.Event{
+		AccountID: acc.ID,
+		Time:      now,
+		Kind:      db.EventBanExpiring,
+		Message:   "Ban expires " + acc.Ban.Time.Local().Format(timeFormat),
+	}
+	if err := s.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("couldn't add ban expiry warning to history (Account-ID: %d), %v", acc.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package ban
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/notify"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	ban := func(d time.Duration) db.NullTime {
+		return db.NullTime{Time: now.Add(d), Valid: true}
+	}
+	accs := []*db.Account{
+		{IGN: "tomorrow", Ban: ban(20 * time.Hour)},
+		{IGN: "later", Ban: ban(48 * time.Hour)},
+		{IGN: "expired", Ban: ban(-time.Hour)},
+		{IGN: "perma", Ban: ban(3 * time.Hour), Perma: true},
+		{IGN: "none"},
+		{IGN: "perma expired", Ban: ban(-time.Hour), Perma: true},
+	}
+	for _, acc := range accs {
+		if err := d.AddAccount(acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Scheduler{DB: d, Events: &notify.Dispatcher{DB: d}}
+	for i := 0; i < 2; i++ {
+		if err := s.Check(now.Add(time.Duration(i) * time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := d.AllEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[int][]string)
+	for _, e := range events {
+		kinds[e.AccountID] = append(kinds[e.AccountID], e.Kind)
+	}
+	want := map[int][]string{
+		accs[0].ID: {db.EventBanExpiring},
+		accs[2].ID: {db.EventBanExpired},
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("expected events %v, got %v", want, kinds)
+	}
+
+	penalties, err := d.AllPenalties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(penalties) != 1 || penalties[0].AccountID != accs[2].ID || penalties[0].Type != db.PenaltyTempBan {
+		t.Fatalf("expected the expired ban in the penalties, got %+v", penalties)
+	}
+
+	for i, wantBan := range []bool{true, true, false, true, false, true} {
+		acc, err := d.Account(accs[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acc.Ban.Valid != wantBan {
+			t.Errorf("%s: expected ban %t, got %+v", acc.IGN, wantBan, acc.Ban)
+		}
+	}
+}
+
+func TestCheckContinues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The history of the first account can't be read.
+	if err := ioutil.WriteFile(filepath.Join(dir, "events.csv"), []byte("1,1,invalid,ban_expiring,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	accs := []*db.Account{
+		{IGN: "broken", Tag: "a", Ban: db.NullTime{Time: now.Add(20 * time.Hour), Valid: true}},
+		{IGN: "expired", Tag: "b", Ban: db.NullTime{Time: now.Add(-time.Hour), Valid: true}},
+	}
+	for _, acc := range accs {
+		if err := d.AddAccount(acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if accs[0].ID != 1 {
+		t.Fatalf("expected id 1, got %d", accs[0].ID)
+	}
+
+	s := &Scheduler{DB: d, Events: &notify.Dispatcher{DB: d}, Logger: log.New(ioutil.Discard, "", 0)}
+	err = s.Check(now)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 ban checks failed") {
+		t.Fatalf("expected only the failure of broken, got %v", err)
+	}
+	acc, err := d.Account(accs[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Ban.Valid {
+		t.Fatalf("ban after the failure not cleared, got %+v", acc.Ban)
+	}
+}
@@ -2,15 +2,16 @@ package db
 
 import (
 	"database/sql"
-	"encoding/csv"
-	"io"
+	"errors"
+	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func (d *DB) Account(id int) (*Account, error) {
-	accs, err := d.all()
+	accs, err := d.accounts.all()
 	if err != nil {
 		return nil, err
 	}
@@ -24,7 +25,7 @@ func (d *DB) Account(id int) (*Account, error) {
 }
 
 func (d *DB) Accounts() ([]*Account, error) {
-	records, err := d.all()
+	records, err := d.accounts.all()
 	if err != nil {
 		return nil, err
 	}
@@ -76,27 +77,17 @@ func (d *DB) Accounts() ([]*Account, error) {
 }
 
 func (d *DB) AddAccount(acc *Account) error {
-	d.Lock()
-	defer d.Unlock()
-
-	acc.ID = d.ctr
-	d.ctr++
-	record := accToRecord(acc)
-
-	if _, err := d.Seek(0, io.SeekEnd); err != nil {
-		return err
-	}
-	w := csv.NewWriter(d)
-	if err := w.Write(record); err != nil {
+	id, err := d.accounts.add(accToRecord(acc))
+	if err != nil {
 		return err
 	}
-	w.Flush()
-	return w.Error()
+	acc.ID = id
+	return nil
 }
 
 func (d *DB) RemoveAccount(id int) error {
 	idStr := strconv.Itoa(id)
-	return d.update(func(accs [][]string) ([][]string, error) {
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
 		for i, a := range accs {
 			if a[aID] == idStr {
 				accs[i] = accs[len(accs)-1]
@@ -110,13 +101,72 @@ func (d *DB) RemoveAccount(id int) error {
 
 func (d *DB) EditAccount(id int, acc *Account) error {
 	idStr := strconv.Itoa(id)
-	return d.update(func(accs [][]string) ([][]string, error) {
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
 		for i, a := range accs {
 			if a[aID] == idStr {
-				elo := accs[i][aElo]
+				old := accs[i]
 				accs[i] = accToRecord(acc)
 				accs[i][aID] = idStr
-				accs[i][aElo] = elo
+				for _, col := range []int{aElo, aEloFlex, aEloTFT, aEloUpdated, aEloChecked, aPUUID, aLevel, aReview, aLastPlayed, aRecentGames} {
+					accs[i][col] = old[col]
+				}
+				if old[aRegion] != acc.Region || old[aIGN] != acc.IGN || old[aTagLine] != acc.TagLine {
+					accs[i][aPUUID] = ""
+				}
+				return accs, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// EditElo stores the result of a successful elo lookup made at time t.
+// Only the queues contained in ranks are changed.
+func (d *DB) EditElo(id int, ranks map[Queue]string, t time.Time) error {
+	idStr := strconv.Itoa(id)
+	tStr := t.Format(timeFormat)
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] == idStr {
+				for q, rank := range ranks {
+					col, ok := queueColumns[q]
+					if !ok {
+						return nil, fmt.Errorf("unknown queue %q", q)
+					}
+					accs[i][col] = rank
+				}
+				accs[i][aEloUpdated] = tStr
+				accs[i][aEloChecked] = tStr
+				return accs, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// EditEloChecked records a failed elo lookup made at time t.
+func (d *DB) EditEloChecked(id int, t time.Time) error {
+	idStr := strconv.Itoa(id)
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] == idStr {
+				accs[i][aEloChecked] = t.Format(timeFormat)
+				return accs, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// EditRiotID sets the Riot ID and PUUID of an account.
+func (d *DB) EditRiotID(id int, ign, tagLine, puuid string) error {
+	idStr := strconv.Itoa(id)
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] == idStr {
+				accs[i][aIGN] = ign
+				accs[i][aTagLine] = tagLine
+				accs[i][aPUUID] = puuid
 				return accs, nil
 			}
 		}
@@ -124,12 +174,15 @@ func (d *DB) EditAccount(id int, acc *Account) error {
 	})
 }
 
-func (d *DB) EditElo(id int, elo string) error {
+// EditLevel sets the summoner level of an account
+// and whether it is below level 30.
+func (d *DB) EditLevel(id, level int) error {
 	idStr := strconv.Itoa(id)
-	return d.update(func(accs [][]string) ([][]string, error) {
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
 		for i, a := range accs {
 			if a[aID] == idStr {
-				accs[i][aElo] = elo
+				accs[i][aLevel] = strconv.Itoa(level)
+				accs[i][aPre30] = strconv.FormatBool(level < 30)
 				return accs, nil
 			}
 		}
@@ -137,6 +190,167 @@ func (d *DB) EditElo(id int, elo string) error {
 	})
 }
 
+// EditReview flags an account for review by a human,
+// an empty reason clears the flag.
+func (d *DB) EditReview(id int, reason string) error {
+	idStr := strconv.Itoa(id)
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] == idStr {
+				accs[i][aReview] = reason
+				return accs, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// ErrCheckedOut is returned by CheckOut
+// if the account isn't checked out by the expected user.
+var ErrCheckedOut = errors.New("db: account is checked out by another user")
+
+// CheckOut sets the user of the account with id to to,
+// if it is currently checked out by from.
+func (d *DB) CheckOut(id int, from, to string) error {
+	idStr := strconv.Itoa(id)
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] != idStr {
+				continue
+			}
+			if a[aUser] != from {
+				return nil, ErrCheckedOut
+			}
+			accs[i][aUser] = to
+			return accs, nil
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// EditActivity stores when the account was last played
+// and how many games were played recently.
+func (d *DB) EditActivity(id int, lastPlayed NullTime, recentGames int) error {
+	idStr := strconv.Itoa(id)
+	return d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] == idStr {
+				accs[i][aLastPlayed] = formatNullTime(lastPlayed)
+				accs[i][aRecentGames] = strconv.Itoa(recentGames)
+				return accs, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// ClearBan removes the ban of an account if it still ends at until.
+// It reports whether the ban was removed.
+func (d *DB) ClearBan(id int, until time.Time) (bool, error) {
+	idStr := strconv.Itoa(id)
+	cleared := false
+	err := d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] == idStr {
+				ban, err := parseNullTime(a[aBan])
+				if err != nil {
+					return nil, err
+				}
+				if ban.Valid && ban.Time.Equal(until) {
+					accs[i][aBan] = formatNullTime(NullTime{})
+					cleared = true
+				}
+				return accs, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+	return cleared, err
+}
+
+// ErrNoLeaverbuster is returned by PlayLeaverbuster
+// if the account has no leaverbuster games left.
+var ErrNoLeaverbuster = errors.New("db: no leaverbuster games left")
+
+// PlayLeaverbuster counts games played in the leaverbuster queue until t
+// and returns the number of games left. Once none are left, the delay is removed
+// and the leaverbuster penalties of the account end at t.
+func (d *DB) PlayLeaverbuster(id, games int, t time.Time) (int, error) {
+	idStr := strconv.Itoa(id)
+	remaining := 0
+	err := d.accounts.update(func(accs [][]string) ([][]string, error) {
+		for i, a := range accs {
+			if a[aID] != idStr {
+				continue
+			}
+			left, err := strconv.Atoi(a[aLBGames])
+			if err != nil {
+				return nil, err
+			}
+			if left <= 0 {
+				return nil, ErrNoLeaverbuster
+			}
+			remaining = left - games
+			if remaining < 0 {
+				remaining = 0
+			}
+			accs[i][aLBGames] = strconv.Itoa(remaining)
+			accs[i][aLBSince] = t.Format(timeFormat)
+			if remaining == 0 {
+				accs[i][aLeaverbuster] = "0"
+				accs[i][aLBSince] = nullTime
+			}
+			return accs, nil
+		}
+		return nil, sql.ErrNoRows
+	})
+	if err != nil || remaining > 0 {
+		return remaining, err
+	}
+	return 0, d.EndPenalties(id, PenaltyLeaverbuster, t)
+}
+
+// Priority controls how often the elo of an account is updated automatically.
+type Priority int
+
+const (
+	// PriorityAuto is PriorityNormal, unless the account is permanently banned.
+	PriorityAuto Priority = iota
+	PriorityOff
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+type Queue string
+
+const (
+	QueueSolo Queue = "solo"
+	QueueFlex Queue = "flex"
+	QueueTFT  Queue = "tft"
+)
+
+var Queues = []Queue{QueueSolo, QueueFlex, QueueTFT}
+
+func (q Queue) Name() string {
+	switch q {
+	case QueueSolo:
+		return "Solo/Duo"
+	case QueueFlex:
+		return "Flex"
+	case QueueTFT:
+		return "TFT"
+	default:
+		return string(q)
+	}
+}
+
+var queueColumns = map[Queue]int{
+	QueueSolo: aElo,
+	QueueFlex: aEloFlex,
+	QueueTFT:  aEloTFT,
+}
+
 type Account struct {
 	ID              int
 	Region          string
@@ -150,7 +364,59 @@ type Account struct {
 	Perma           bool
 	PasswordChanged bool
 	Pre30           bool
-	Elo             string
+	Elo             string // solo/duo
+	EloFlex         string
+	EloTFT          string
+	EloUpdated      NullTime
+	EloChecked      NullTime
+	TagLine         string
+	// PUUID is the permanent id of the player, it is kept when the Riot ID changes.
+	PUUID string
+	// Level is the summoner level, 0 if unknown.
+	Level int
+	// Review is the reason why the account needs to be checked by a human.
+	Review string
+	// LastPlayed is the end of the last game.
+	LastPlayed NullTime
+	// RecentGames is the number of games played in the last two weeks.
+	RecentGames int
+	Track       Priority
+	// LeaverbusterGames is the number of games left in the leaverbuster queue,
+	// Leaverbuster is the queue delay of each game in minutes.
+	LeaverbusterGames int
+	// LeaverbusterSince is the time the games were last counted.
+	LeaverbusterSince NullTime
+}
+
+// TrackPriority resolves PriorityAuto.
+func (a Account) TrackPriority() Priority {
+	if a.Track != PriorityAuto {
+		return a.Track
+	}
+	if a.Perma {
+		return PriorityOff
+	}
+	return PriorityNormal
+}
+
+// Rank returns the rank in the queue q.
+func (a Account) Rank(q Queue) string {
+	switch q {
+	case QueueFlex:
+		return a.EloFlex
+	case QueueTFT:
+		return a.EloTFT
+	default:
+		return a.Elo
+	}
+}
+
+// RiotID returns the IGN and tag line in the form gameName#tagLine.
+func (a Account) RiotID() string {
+	if a.TagLine == "" {
+		return a.IGN
+	}
+	return a.IGN + "#" + a.TagLine
 }
 
 const (
@@ -167,7 +433,20 @@ const (
 	aPasswordChanged = 10
 	aPre30           = 11
 	aElo             = 12
-	aLen             = 13
+	aEloUpdated      = 13
+	aEloChecked      = 14
+	aTagLine         = 15
+	aPUUID           = 16
+	aLevel           = 17
+	aReview          = 18
+	aEloFlex         = 19
+	aEloTFT          = 20
+	aLastPlayed      = 21
+	aRecentGames     = 22
+	aTrack           = 23
+	aLBGames         = 24
+	aLBSince         = 25
+	aLen             = 26
 )
 
 const (
@@ -175,12 +454,44 @@ const (
 	timeFormat = time.RFC3339
 )
 
-func accToRecord(a *Account) []string {
-	ban := nullTime
-	if a.Ban.Valid {
-		ban = a.Ban.Time.Format(timeFormat)
+// defaultRecord is used to fill up the columns
+// missing in records written by older versions.
+var defaultRecord = accToRecord(&Account{})
+
+// migrate converts a record written by an older version to the current layout.
+func migrate(r []string) []string {
+	n := len(r)
+	if n >= aLen {
+		return r
+	}
+	r = append(r, defaultRecord[n:]...)
+	if n <= aTagLine {
+		if i := strings.LastIndex(r[aIGN], "#"); i >= 0 {
+			r[aIGN], r[aTagLine] = r[aIGN][:i], r[aIGN][i+1:]
+		}
 	}
+	return r
+}
 
+func formatNullTime(t NullTime) string {
+	if !t.Valid {
+		return nullTime
+	}
+	return t.Time.Format(timeFormat)
+}
+
+func parseNullTime(s string) (NullTime, error) {
+	if s == nullTime {
+		return NullTime{}, nil
+	}
+	t, err := time.Parse(timeFormat, s)
+	if err != nil {
+		return NullTime{}, err
+	}
+	return NullTime{Time: t, Valid: true}, nil
+}
+
+func accToRecord(a *Account) []string {
 	s := make([]string, aLen)
 	s[aID] = strconv.Itoa(a.ID)
 	s[aRegion] = a.Region
@@ -190,11 +501,24 @@ func accToRecord(a *Account) []string {
 	s[aPassword] = a.Password
 	s[aUser] = a.User
 	s[aLeaverbuster] = strconv.Itoa(a.Leaverbuster)
-	s[aBan] = ban
+	s[aBan] = formatNullTime(a.Ban)
 	s[aPerma] = strconv.FormatBool(a.Perma)
 	s[aPasswordChanged] = strconv.FormatBool(a.PasswordChanged)
 	s[aPre30] = strconv.FormatBool(a.Pre30)
 	s[aElo] = a.Elo
+	s[aEloUpdated] = formatNullTime(a.EloUpdated)
+	s[aEloChecked] = formatNullTime(a.EloChecked)
+	s[aTagLine] = a.TagLine
+	s[aPUUID] = a.PUUID
+	s[aLevel] = strconv.Itoa(a.Level)
+	s[aReview] = a.Review
+	s[aEloFlex] = a.EloFlex
+	s[aEloTFT] = a.EloTFT
+	s[aLastPlayed] = formatNullTime(a.LastPlayed)
+	s[aRecentGames] = strconv.Itoa(a.RecentGames)
+	s[aTrack] = strconv.Itoa(int(a.Track))
+	s[aLBGames] = strconv.Itoa(a.LeaverbusterGames)
+	s[aLBSince] = formatNullTime(a.LeaverbusterSince)
 	return s
 }
 
@@ -219,18 +543,42 @@ func recordToAcc(r []string) (*Account, error) {
 	if err != nil {
 		return nil, err
 	}
+	level, err := strconv.Atoi(r[aLevel])
+	if err != nil {
+		return nil, err
+	}
 
-	ban := NullTime{}
-	banStr := r[aBan]
-	if banStr != nullTime {
-		t, err := time.Parse(timeFormat, banStr)
-		if err != nil {
-			return nil, err
-		}
-		ban = NullTime{
-			Time:  t,
-			Valid: true,
-		}
+	ban, err := parseNullTime(r[aBan])
+	if err != nil {
+		return nil, err
+	}
+	eloUpdated, err := parseNullTime(r[aEloUpdated])
+	if err != nil {
+		return nil, err
+	}
+	eloChecked, err := parseNullTime(r[aEloChecked])
+	if err != nil {
+		return nil, err
+	}
+	lastPlayed, err := parseNullTime(r[aLastPlayed])
+	if err != nil {
+		return nil, err
+	}
+	recentGames, err := strconv.Atoi(r[aRecentGames])
+	if err != nil {
+		return nil, err
+	}
+	track, err := strconv.Atoi(r[aTrack])
+	if err != nil {
+		return nil, err
+	}
+	lbGames, err := strconv.Atoi(r[aLBGames])
+	if err != nil {
+		return nil, err
+	}
+	lbSince, err := parseNullTime(r[aLBSince])
+	if err != nil {
+		return nil, err
 	}
 
 	return &Account{
@@ -247,5 +595,19 @@ func recordToAcc(r []string) (*Account, error) {
 		PasswordChanged: passwordChanged,
 		Pre30:           pre30,
 		Elo:             r[aElo],
+		EloUpdated:      eloUpdated,
+		EloChecked:      eloChecked,
+		TagLine:         r[aTagLine],
+		PUUID:           r[aPUUID],
+		Level:           level,
+		Review:          r[aReview],
+		EloFlex:         r[aEloFlex],
+		EloTFT:          r[aEloTFT],
+		LastPlayed:      lastPlayed,
+		RecentGames:     recentGames,
+		Track:           Priority(track),
+
+		LeaverbusterGames: lbGames,
+		LeaverbusterSince: lbSince,
 	}, nil
 }
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditLockout = "lockout"
+
+	AuditTwoFactorEnabled  = "2fa_enabled"
+	AuditTwoFactorDisabled = "2fa_disabled"
+	AuditRecoveryCodes     = "recovery_codes"
+	AuditRecoveryCodeUsed  = "recovery_code_used"
+	AuditTwoFactorReset    = "2fa_reset"
+	AuditTwoFactorRequired = "2fa_required"
+
+	AuditUserCreated     = "user_created"
+	AuditUserDisabled    = "user_disabled"
+	AuditUserEnabled     = "user_enabled"
+	AuditUserDeleted     = "user_deleted"
+	AuditRoleChanged     = "role_changed"
+	AuditPasswordReset   = "password_reset"
+	AuditPasswordChanged = "password_changed"
+)
+
+// AuditEntry records a security relevant action.
+type AuditEntry struct {
+	ID   int
+	Time time.Time
+	Kind string
+	// Username is the user the entry is about,
+	// it doesn't have to exist.
+	Username string
+	IP       string
+	Message  string
+}
+
+func (d *DB) AddAudit(e *AuditEntry) error {
+	id, err := d.audit.add(auditToRecord(e))
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// AuditLog returns every audit entry, newest first.
+func (d *DB) AuditLog() ([]*AuditEntry, error) {
+	records, err := d.audit.all()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*AuditEntry, 0)
+	for _, r := range records {
+		e, err := recordToAudit(r)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		p, q := entries[i], entries[j]
+		if p.Time.Equal(q.Time) {
+			return p.ID > q.ID
+		}
+		return p.Time.After(q.Time)
+	})
+	return entries, nil
+}
+
+const (
+	auID       = 0
+	auTime     = 1
+	auKind     = 2
+	auUsername = 3
+	auIP       = 4
+	auMessage  = 5
+	auLen      = 6
+)
+
+func padAudit(r []string) []string {
+	for len(r) < auLen {
+		r = append(r, "")
+	}
+	return r
+}
+
+func auditToRecord(e *AuditEntry) []string {
+	r := make([]string, auLen)
+	r[auID] = strconv.Itoa(e.ID)
+	r[auTime] = e.Time.Format(timeFormat)
+	r[auKind] = e.Kind
+	r[auUsername] = e.Username
+	r[auIP] = e.IP
+	r[auMessage] = e.Message
+	return r
+}
+
+func recordToAudit(r []string) (*AuditEntry, error) {
+	id, err := strconv.Atoi(r[auID])
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(timeFormat, r[auTime])
+	if err != nil {
+		return nil, err
+	}
+	return &AuditEntry{
+		ID:       id,
+		Time:     t,
+		Kind:     r[auKind],
+		Username: r[auUsername],
+		IP:       r[auIP],
+		Message:  r[auMessage],
+	}, nil
+}
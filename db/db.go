@@ -1,12 +1,6 @@
 package db
 
 import (
-	"encoding/csv"
-	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"sync"
 	"time"
 )
 
@@ -16,91 +10,67 @@ type NullTime struct {
 }
 
 type DB struct {
-	dir string
-	sync.RWMutex
-	*os.File
-	ctr int
+	accounts      *table
+	events        *table
+	subscriptions *table
+	penalties     *table
+	sessions      *table
+	audit         *table
+	twoFactor     *table
+	users         *table
+	settings      *table
 }
 
-const accFile = "accounts.csv"
+const (
+	accFile          = "accounts.csv"
+	eventFile        = "events.csv"
+	subscriptionFile = "subscriptions.csv"
+	penaltyFile      = "penalties.csv"
+	sessionFile      = "sessions.csv"
+	auditFile        = "audit.csv"
+	twoFactorFile    = "twofactor.csv"
+	userFile         = "users.csv"
+	settingFile      = "settings.csv"
+)
 
 func Init(dir string) (*DB, error) {
-	d := &DB{dir: dir}
-
-	if err := d.open(); err != nil {
-		return nil, err
-	}
-
-	accs, err := d.Accounts()
-	if err != nil {
-		d.File.Close()
-		return nil, err
-	}
-	for _, a := range accs {
-		if a.ID > d.ctr {
-			d.ctr = a.ID
+	d := new(DB)
+
+	for _, t := range []struct {
+		dest    **table
+		name    string
+		migrate func([]string) []string
+	}{
+		{&d.accounts, accFile, migrate},
+		{&d.events, eventFile, padEvent},
+		{&d.subscriptions, subscriptionFile, padSubscription},
+		{&d.penalties, penaltyFile, padPenalty},
+		{&d.sessions, sessionFile, padSession},
+		{&d.audit, auditFile, padAudit},
+		{&d.twoFactor, twoFactorFile, padTwoFactor},
+		{&d.users, userFile, padUser},
+		{&d.settings, settingFile, padSetting},
+	} {
+		var err error
+		*t.dest, err = openTable(dir, t.name, t.migrate)
+		if err != nil {
+			d.Close()
+			return nil, err
 		}
 	}
-	d.ctr++
 
 	return d, nil
 }
 
-func (d *DB) open() error {
-	f, err := os.OpenFile(
-		filepath.Join(d.dir, accFile),
-		os.O_RDWR|os.O_CREATE|os.O_SYNC,
-		0644,
-	)
-	if err != nil {
-		return err
-	}
-	d.File = f
-	return nil
-}
-
-func (d *DB) all() ([][]string, error) {
-	d.RLock()
-	defer d.RUnlock()
-	return d.allUnsync()
-}
-
-func (d *DB) allUnsync() ([][]string, error) {
-	if _, err := d.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
-	return csv.NewReader(d).ReadAll()
-}
-
-func (d *DB) update(f func([][]string) ([][]string, error)) error {
-	d.Lock()
-	defer d.Unlock()
-
-	records, err := d.allUnsync()
-	if err != nil {
-		return err
-	}
-	records, err = f(records)
-	if err != nil {
-		return err
-	}
-
-	tmp, err := ioutil.TempFile(d.dir, accFile)
-	if err != nil {
-		return err
-	}
-	name := tmp.Name()
-
-	wErr := csv.NewWriter(tmp).WriteAll(records)
-	if err := tmp.Close(); err != nil {
-		return err
-	}
-	if wErr != nil {
-		return err
-	}
-
-	if err := os.Rename(name, filepath.Join(d.dir, accFile)); err != nil {
-		return err
+func (d *DB) Close() error {
+	var err error
+	for _, t := range []*table{d.accounts, d.events, d.subscriptions, d.penalties, d.sessions, d.audit, d.twoFactor, d.users, d.settings} {
+		if t == nil {
+			continue
+		}
+		if cErr := t.close(); cErr != nil && err == nil {
+			err = cErr
+		}
 	}
-	return d.open()
+	return err
 }
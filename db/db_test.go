@@ -1,10 +1,13 @@
 package db
 
 import (
+	"database/sql"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestDB(t *testing.T) {
@@ -30,6 +33,7 @@ func TestDB(t *testing.T) {
 			Region:   "euw",
 			Tag:      "blub",
 			IGN:      "player0",
+			TagLine:  "EUW",
 			Username: "p0",
 			Password: "pass",
 			User:     "me",
@@ -45,6 +49,7 @@ func TestDB(t *testing.T) {
 			User:         "me",
 			Leaverbuster: 10,
 			Perma:        true,
+			Track:        PriorityHigh,
 		},
 		{
 			ID:              3,
@@ -97,15 +102,36 @@ func TestDB(t *testing.T) {
 	}
 
 	elo := "Challenger"
-	if err := d.EditElo(2, elo); err != nil {
+	updated := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	flex := "Iron I"
+	if err := d.EditElo(2, map[Queue]string{QueueSolo: elo, QueueFlex: flex}, updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EditElo(2, map[Queue]string{"arena": "Gold"}, updated); err == nil {
+		t.Fatal("EditElo: expected error for unknown queue")
+	}
+	checked := updated.Add(time.Hour)
+	if err := d.EditEloChecked(2, checked); err != nil {
 		t.Fatal(err)
 	}
 	acc, err = d.Account(2)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if acc.Elo != elo {
-		t.Fatalf("EditElo: expected %s, got %s", elo, acc.Elo)
+	if acc.Elo != elo || acc.Rank(QueueFlex) != flex || acc.EloTFT != "" {
+		t.Fatalf("EditElo: expected %s and %s, got %+v", elo, flex, acc)
+	}
+	if !acc.EloUpdated.Valid || !acc.EloUpdated.Time.Equal(updated) {
+		t.Fatalf("EditElo: expected update time %v, got %+v", updated, acc.EloUpdated)
+	}
+	if !acc.EloChecked.Valid || !acc.EloChecked.Time.Equal(checked) {
+		t.Fatalf("EditEloChecked: expected lookup time %v, got %+v", checked, acc.EloChecked)
+	}
+	if err := d.EditAccount(2, accounts[0]); err != nil {
+		t.Fatal(err)
+	}
+	if acc, err = d.Account(2); err != nil || !acc.EloUpdated.Valid {
+		t.Fatalf("updated elo time on edit (err: %v)", err)
 	}
 
 	if err := d.RemoveAccount(2); err != nil {
@@ -124,3 +150,588 @@ func TestDB(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDBOldRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := "1,euw,blub,player0#1234,p0,pass,me,0,,false,false,false,Wood IV\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, accFile), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.AddAccount(&Account{Region: "na", IGN: "player1"}); err != nil {
+		t.Fatal(err)
+	}
+	accs, err := d.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 2 {
+		t.Fatalf("expected 2 accounts, got %d", len(accs))
+	}
+	if accs[0].Elo != "Wood IV" || accs[0].EloUpdated.Valid ||
+		accs[0].IGN != "player0" || accs[0].TagLine != "1234" {
+		t.Fatalf("old account not read correctly: %+v", accs[0])
+	}
+	if accs[1].ID != 2 {
+		t.Fatalf("expected id 2 for new account, got %d", accs[1].ID)
+	}
+}
+
+func TestRiotIDAndEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	acc := &Account{Region: "euw", IGN: "player0", TagLine: "EUW"}
+	if err := d.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EditRiotID(acc.ID, "player1", "1234", "puuid"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Account(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RiotID() != "player1#1234" || got.PUUID != "puuid" {
+		t.Fatalf("EditRiotID: got %s (PUUID: %s)", got.RiotID(), got.PUUID)
+	}
+
+	got.Tag = "blub"
+	if err := d.EditAccount(acc.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.Account(acc.ID); err != nil || got.PUUID != "puuid" {
+		t.Fatalf("PUUID not kept on edit (err: %v)", err)
+	}
+	got.IGN = "player2"
+	if err := d.EditAccount(acc.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.Account(acc.ID); err != nil || got.PUUID != "" {
+		t.Fatalf("PUUID kept after IGN changed (err: %v)", err)
+	}
+
+	if err := d.EditLevel(acc.ID, 12); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.Account(acc.ID); err != nil || got.Level != 12 || !got.Pre30 {
+		t.Fatalf("EditLevel: got %+v (err: %v)", got, err)
+	}
+	if err := d.EditReview(acc.ID, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	played := NullTime{Time: time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC), Valid: true}
+	if err := d.EditActivity(acc.ID, played, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EditAccount(acc.ID, &Account{Region: "euw", IGN: "player2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.Account(acc.ID); err != nil || got.Level != 12 || got.Review != "gone" ||
+		!got.LastPlayed.Time.Equal(played.Time) || got.RecentGames != 7 {
+		t.Fatalf("level or review not kept on edit: %+v (err: %v)", got, err)
+	}
+
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	events := []*Event{
+		{AccountID: acc.ID, Time: now, Kind: EventRename, Message: "first"},
+		{AccountID: acc.ID + 1, Time: now, Kind: EventRename, Message: "other"},
+		{AccountID: acc.ID, Time: now.Add(time.Hour), Kind: EventRename, Message: "second"},
+	}
+	for _, e := range events {
+		if err := d.AddEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := d.Events(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Message != "second" || history[1].Message != "first" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if history[0].ID != events[2].ID {
+		t.Fatalf("expected id %d, got %d", events[2].ID, history[0].ID)
+	}
+	all, err := d.AllEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Message != "second" || all[1].Message != "other" {
+		t.Fatalf("unexpected events %+v", all)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if _, err := d.Subscription("me"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+	for _, s := range []*Subscription{
+		{Username: "me", Email: "old@example.com", Topics: []string{"digest"}},
+		{Username: "other", Email: "other@example.com"},
+		{Username: "me", Email: "me@example.com", Topics: []string{"digest", "rank_dropped"}},
+	} {
+		if err := d.SetSubscription(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, err := d.Subscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("expected 2 subscriptions, got %d", len(subs))
+	}
+	s, err := d.Subscription("me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Subscription{ID: 1, Username: "me", Email: "me@example.com", Topics: []string{"digest", "rank_dropped"}}
+	if !reflect.DeepEqual(s, want) || !s.Has("rank_dropped") {
+		t.Fatalf("expected %+v, got %+v", want, s)
+	}
+}
+
+func TestPenalties(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	at := func(d time.Duration) NullTime {
+		return NullTime{Time: now.Add(d), Valid: true}
+	}
+	penalties := []*Penalty{
+		{AccountID: 1, Type: PenaltyChat, Start: at(-48 * time.Hour), End: at(-24 * time.Hour), Reason: "flame"},
+		{AccountID: 1, Type: PenaltyTempBan, Start: at(-time.Hour), End: at(13 * 24 * time.Hour)},
+		{AccountID: 2, Type: PenaltyPerma, Start: at(-time.Hour), Appeal: AppealPending},
+		{AccountID: 1, Type: PenaltyRanked, Start: at(-2 * time.Hour)},
+	}
+	for _, p := range penalties {
+		if err := d.AddPenalty(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := d.Penalties(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].ID != penalties[1].ID || got[2].ID != penalties[0].ID {
+		t.Fatalf("expected penalties of account 1 newest first, got %+v", got)
+	}
+	for i, want := range []bool{false, true, true, true} {
+		if active := penalties[i].Active(now); active != want {
+			t.Errorf("penalty %d: expected active %t, got %t", penalties[i].ID, want, active)
+		}
+	}
+
+	p := *penalties[2]
+	p.AccountID = 1
+	p.Appeal = AppealAccepted
+	if err := d.EditPenalty(&p); err != nil {
+		t.Fatal(err)
+	}
+	edited, err := d.Penalty(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.AccountID != 2 || edited.Appeal != AppealAccepted || edited.Active(now) {
+		t.Fatalf("unexpected penalty after edit: %+v", edited)
+	}
+
+	if err := d.RemovePenalty(penalties[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.Penalties(1); err != nil || len(got) != 2 {
+		t.Fatalf("expected 2 penalties after removal, got %d (err: %v)", len(got), err)
+	}
+}
+
+func TestPlayLeaverbuster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	acc := &Account{
+		IGN:               "player0",
+		Leaverbuster:      5,
+		LeaverbusterGames: 3,
+		LeaverbusterSince: NullTime{Time: now, Valid: true},
+	}
+	if err := d.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	p := &Penalty{AccountID: acc.ID, Type: PenaltyLeaverbuster, Start: acc.LeaverbusterSince}
+	if err := d.AddPenalty(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if left, err := d.PlayLeaverbuster(acc.ID, 1, now.Add(time.Hour)); err != nil || left != 2 {
+		t.Fatalf("expected 2 games left, got %d (err: %v)", left, err)
+	}
+	got, err := d.Account(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaverbuster != 5 || !got.LeaverbusterSince.Time.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected leaverbuster after a game: %+v", got)
+	}
+
+	done := now.Add(2 * time.Hour)
+	if left, err := d.PlayLeaverbuster(acc.ID, 5, done); err != nil || left != 0 {
+		t.Fatalf("expected no games left, got %d (err: %v)", left, err)
+	}
+	if got, err = d.Account(acc.ID); err != nil || got.Leaverbuster != 0 || got.LeaverbusterSince.Valid {
+		t.Fatalf("leaverbuster not cleared: %+v (err: %v)", got, err)
+	}
+	if p, err = d.Penalty(p.ID); err != nil || !p.End.Time.Equal(done) {
+		t.Fatalf("leaverbuster penalty not ended: %+v (err: %v)", p, err)
+	}
+	if _, err := d.PlayLeaverbuster(acc.ID, 1, done); err != ErrNoLeaverbuster {
+		t.Fatalf("expected %v, got %v", ErrNoLeaverbuster, err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	sessions := []*Session{
+		{TokenHash: "a", Username: "me", Created: now, LastSeen: now, IP: "127.0.0.1", UserAgent: "curl"},
+		{TokenHash: "b", Username: "me", Created: now, LastSeen: now},
+		{TokenHash: "c", Username: "other", Created: now, LastSeen: now},
+	}
+	for _, s := range sessions {
+		if err := d.AddSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.TouchSession("a", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ConfirmSession("a", now.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemoveSession("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions survive a restart.
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	got, err := d.Session("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := *sessions[0]
+	want.LastSeen = now.Add(time.Hour)
+	want.Confirmed = now.Add(30 * time.Minute)
+	if !reflect.DeepEqual(*got, want) {
+		t.Fatalf("expected %+v, got %+v", want, *got)
+	}
+	if _, err := d.Session("b"); err != sql.ErrNoRows {
+		t.Fatalf("removed session: expected %v, got %v", sql.ErrNoRows, err)
+	}
+
+	s := &Session{TokenHash: "d", Username: "me", Created: now, LastSeen: now}
+	if err := d.AddSession(s); err != nil {
+		t.Fatal(err)
+	}
+	mine, err := d.Sessions("me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine) != 2 || mine[0].TokenHash != "a" || mine[1].TokenHash != "d" {
+		t.Fatalf("expected sessions a and d of me, most recent first, got %+v", mine)
+	}
+
+	if err := d.RemoveSessions("me"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Session("a"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+	if _, err := d.Session("c"); err != nil {
+		t.Fatalf("session of other user removed, %v", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if _, err := d.TwoFactor("me"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+	tf := &TwoFactor{Username: "me", Secret: "JBSWY3DPEHPK3PXP", RecoveryCodes: []string{}}
+	if err := d.SetTwoFactor(tf); err != nil {
+		t.Fatal(err)
+	}
+	tf.Enabled = true
+	tf.RecoveryCodes = []string{"a", "b"}
+	if err := d.SetTwoFactor(tf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.TwoFactor("me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tf) {
+		t.Fatalf("expected %+v, got %+v", tf, got)
+	}
+
+	for i, c := range []struct {
+		counter int64
+		want    bool
+	}{{5, true}, {5, false}, {4, false}, {6, true}} {
+		used, err := d.UseTwoFactorCounter("me", c.counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != c.want {
+			t.Errorf("%d: counter %d: expected %t, got %t", i, c.counter, c.want, used)
+		}
+	}
+	for i, c := range []struct {
+		hash string
+		want bool
+	}{{"a", true}, {"a", false}, {"c", false}} {
+		used, err := d.UseRecoveryCode("me", c.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != c.want {
+			t.Errorf("%d: recovery code %s: expected %t, got %t", i, c.hash, c.want, used)
+		}
+	}
+	if got, err = d.TwoFactor("me"); err != nil || !reflect.DeepEqual(got.RecoveryCodes, []string{"b"}) {
+		t.Fatalf("expected the recovery code b, got %+v (err: %v)", got, err)
+	}
+
+	if err := d.RemoveTwoFactor("me"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.TwoFactor("me"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Users from before roles.
+	old := "1,old,hash,false,2020-05-01T12:00:00Z\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, userFile), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if u, err := d.User("old"); err != nil || u.Role != RoleAdmin {
+		t.Fatalf("expected old user to be an admin, got %+v (err: %v)", u, err)
+	}
+	created := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	me := &User{Username: "me", Password: "hash", Created: created, Role: RoleViewer}
+	if err := d.AddUser(me); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddUser(&User{Username: "other", Password: "hash", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddUser(&User{Username: "me", Created: created}); err != ErrUserExists {
+		t.Fatalf("expected %v, got %v", ErrUserExists, err)
+	}
+
+	if err := d.SetPassword("me", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetUserDisabled("me", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetUserRole("me", RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	me.Password, me.Disabled, me.Role = "new", true, RoleEditor
+	got, err := d.User("me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, me) {
+		t.Fatalf("expected %+v, got %+v", me, got)
+	}
+	if err := d.SetPassword("unknown", "new"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+
+	if err := d.RemoveUser("me"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.User("me"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+	users, err := d.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].Username != "other" {
+		t.Fatalf("expected old and other, got %+v", users)
+	}
+}
+
+func TestCheckOut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	acc := &Account{Region: "euw", IGN: "player0"}
+	if err := d.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []struct {
+		from, to string
+		want     error
+	}{
+		{"", "me", nil},
+		{"", "other", ErrCheckedOut},
+		{"other", "", ErrCheckedOut},
+		{"me", "", nil},
+	} {
+		if err := d.CheckOut(acc.ID, c.from, c.to); err != c.want {
+			t.Fatalf("%d: expected %v, got %v", i, c.want, err)
+		}
+	}
+	if err := d.CheckOut(acc.ID+1, "", "me"); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+	got, err := d.Account(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.User != "" {
+		t.Fatalf("expected the account to be returned, got user %q", got.User)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if _, err := d.Setting(SettingRequireTwoFactor); err != sql.ErrNoRows {
+		t.Fatalf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+	for _, v := range []string{"true", "false"} {
+		if err := d.SetSetting(SettingRequireTwoFactor, v); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := d.Setting(SettingRequireTwoFactor); err != nil || got != v {
+			t.Fatalf("expected %q, got %q (err: %v)", v, got, err)
+		}
+	}
+	records, err := d.settings.all()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected the setting to be replaced, got %v", records)
+	}
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	EventRename    = "rename"
+	EventLevel     = "level"
+	EventReview    = "review"
+	EventPromotion = "promotion"
+	EventDemotion  = "demotion"
+	EventDecay     = "decay"
+
+	EventAdd      = "add"
+	EventEdit     = "edit"
+	EventRemove   = "remove"
+	EventBan      = "ban"
+	EventCheckout = "checkout"
+
+	EventPasswordChanged = "password_changed"
+	EventBanExpiring     = "ban_expiring"
+	EventBanExpired      = "ban_expired"
+	EventLeaverbuster    = "leaverbuster"
+)
+
+// Event is an entry in the history of an account.
+type Event struct {
+	ID        int
+	AccountID int
+	Time      time.Time
+	Kind      string
+	Message   string
+}
+
+func (d *DB) AddEvent(e *Event) error {
+	id, err := d.events.add(eventToRecord(e))
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// Events returns the history of an account, newest first.
+func (d *DB) Events(accountID int) ([]*Event, error) {
+	idStr := strconv.Itoa(accountID)
+	return d.filterEvents(func(r []string) bool {
+		return r[eAccountID] == idStr
+	})
+}
+
+// AllEvents returns the events of every account, newest first.
+func (d *DB) AllEvents() ([]*Event, error) {
+	return d.filterEvents(func([]string) bool {
+		return true
+	})
+}
+
+func (d *DB) filterEvents(keep func([]string) bool) ([]*Event, error) {
+	records, err := d.events.all()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*Event, 0)
+	for _, r := range records {
+		if !keep(r) {
+			continue
+		}
+		e, err := recordToEvent(r)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		p, q := events[i], events[j]
+		if p.Time.Equal(q.Time) {
+			return p.ID > q.ID
+		}
+		return p.Time.After(q.Time)
+	})
+	return events, nil
+}
+
+const (
+	eID        = 0
+	eAccountID = 1
+	eTime      = 2
+	eKind      = 3
+	eMessage   = 4
+	eLen       = 5
+)
+
+func padEvent(r []string) []string {
+	for len(r) < eLen {
+		r = append(r, "")
+	}
+	return r
+}
+
+func eventToRecord(e *Event) []string {
+	s := make([]string, eLen)
+	s[eID] = strconv.Itoa(e.ID)
+	s[eAccountID] = strconv.Itoa(e.AccountID)
+	s[eTime] = e.Time.Format(timeFormat)
+	s[eKind] = e.Kind
+	s[eMessage] = e.Message
+	return s
+}
+
+func recordToEvent(r []string) (*Event, error) {
+	id, err := strconv.Atoi(r[eID])
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := strconv.Atoi(r[eAccountID])
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(timeFormat, r[eTime])
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:        id,
+		AccountID: accountID,
+		Time:      t,
+		Kind:      r[eKind],
+		Message:   r[eMessage],
+	}, nil
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type PenaltyType string
+
+const (
+	PenaltyChat         PenaltyType = "chat"
+	PenaltyRanked       PenaltyType = "ranked"
+	PenaltyTempBan      PenaltyType = "temp_ban"
+	PenaltyPerma        PenaltyType = "perma"
+	PenaltyLeaverbuster PenaltyType = "leaverbuster"
+)
+
+var PenaltyTypes = []PenaltyType{PenaltyChat, PenaltyRanked, PenaltyTempBan, PenaltyPerma, PenaltyLeaverbuster}
+
+func (t PenaltyType) Name() string {
+	switch t {
+	case PenaltyChat:
+		return "Chat restriction"
+	case PenaltyRanked:
+		return "Ranked restriction"
+	case PenaltyTempBan:
+		return "Temporary ban"
+	case PenaltyPerma:
+		return "Permanent ban"
+	case PenaltyLeaverbuster:
+		return "Leaverbuster queue"
+	default:
+		return string(t)
+	}
+}
+
+type Appeal string
+
+const (
+	AppealNone     Appeal = ""
+	AppealPending  Appeal = "pending"
+	AppealAccepted Appeal = "accepted"
+	AppealRejected Appeal = "rejected"
+)
+
+var Appeals = []Appeal{AppealNone, AppealPending, AppealAccepted, AppealRejected}
+
+func (a Appeal) Name() string {
+	switch a {
+	case AppealNone:
+		return "Not appealed"
+	case AppealPending:
+		return "Appeal pending"
+	case AppealAccepted:
+		return "Appeal accepted"
+	case AppealRejected:
+		return "Appeal rejected"
+	default:
+		return string(a)
+	}
+}
+
+// Penalty is a restriction an account received.
+type Penalty struct {
+	ID        int
+	AccountID int
+	Type      PenaltyType
+	// Start is invalid if it isn't known.
+	Start NullTime
+	// End is invalid if the penalty doesn't end.
+	End    NullTime
+	Reason string
+	Appeal Appeal
+}
+
+// Active reports whether p is in effect at now.
+// Penalties lifted by an appeal are never active.
+func (p Penalty) Active(now time.Time) bool {
+	if p.Appeal == AppealAccepted {
+		return false
+	}
+	if p.Start.Valid && p.Start.Time.After(now) {
+		return false
+	}
+	return !p.End.Valid || p.End.Time.After(now)
+}
+
+func (d *DB) AddPenalty(p *Penalty) error {
+	id, err := d.penalties.add(penaltyToRecord(p))
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	return nil
+}
+
+// EditPenalty replaces the penalty with the id p.ID,
+// the account can't be changed.
+func (d *DB) EditPenalty(p *Penalty) error {
+	idStr := strconv.Itoa(p.ID)
+	return d.penalties.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[pID] == idStr {
+				accountID := r[pAccountID]
+				records[i] = penaltyToRecord(p)
+				records[i][pAccountID] = accountID
+				return records, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// EndPenalties sets the end of the penalties of type typ
+// without an end to t.
+func (d *DB) EndPenalties(accountID int, typ PenaltyType, t time.Time) error {
+	idStr := strconv.Itoa(accountID)
+	return d.penalties.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[pAccountID] == idStr && r[pType] == string(typ) && r[pEnd] == nullTime {
+				records[i][pEnd] = t.Format(timeFormat)
+			}
+		}
+		return records, nil
+	})
+}
+
+func (d *DB) RemovePenalty(id int) error {
+	idStr := strconv.Itoa(id)
+	return d.penalties.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[pID] == idStr {
+				return append(records[:i], records[i+1:]...), nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+func (d *DB) Penalty(id int) (*Penalty, error) {
+	records, err := d.penalties.all()
+	if err != nil {
+		return nil, err
+	}
+	idStr := strconv.Itoa(id)
+	for _, r := range records {
+		if r[pID] == idStr {
+			return recordToPenalty(r)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// Penalties returns the penalties of an account, the most recent first.
+func (d *DB) Penalties(accountID int) ([]*Penalty, error) {
+	all, err := d.AllPenalties()
+	if err != nil {
+		return nil, err
+	}
+	penalties := make([]*Penalty, 0)
+	for _, p := range all {
+		if p.AccountID == accountID {
+			penalties = append(penalties, p)
+		}
+	}
+	return penalties, nil
+}
+
+// AllPenalties returns the penalties of every account, the most recent first.
+func (d *DB) AllPenalties() ([]*Penalty, error) {
+	records, err := d.penalties.all()
+	if err != nil {
+		return nil, err
+	}
+	penalties := make([]*Penalty, 0)
+	for _, r := range records {
+		p, err := recordToPenalty(r)
+		if err != nil {
+			return nil, err
+		}
+		penalties = append(penalties, p)
+	}
+	sort.Slice(penalties, func(i, j int) bool {
+		p, q := penalties[i], penalties[j]
+		if !p.Start.Time.Equal(q.Start.Time) {
+			return p.Start.Time.After(q.Start.Time)
+		}
+		return p.ID > q.ID
+	})
+	return penalties, nil
+}
+
+const (
+	pID        = 0
+	pAccountID = 1
+	pType      = 2
+	pStart     = 3
+	pEnd       = 4
+	pReason    = 5
+	pAppeal    = 6
+	pLen       = 7
+)
+
+func padPenalty(r []string) []string {
+	for len(r) < pLen {
+		r = append(r, "")
+	}
+	return r
+}
+
+func penaltyToRecord(p *Penalty) []string {
+	r := make([]string, pLen)
+	r[pID] = strconv.Itoa(p.ID)
+	r[pAccountID] = strconv.Itoa(p.AccountID)
+	r[pType] = string(p.Type)
+	r[pStart] = formatNullTime(p.Start)
+	r[pEnd] = formatNullTime(p.End)
+	r[pReason] = p.Reason
+	r[pAppeal] = string(p.Appeal)
+	return r
+}
+
+func recordToPenalty(r []string) (*Penalty, error) {
+	id, err := strconv.Atoi(r[pID])
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := strconv.Atoi(r[pAccountID])
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseNullTime(r[pStart])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseNullTime(r[pEnd])
+	if err != nil {
+		return nil, err
+	}
+	return &Penalty{
+		ID:        id,
+		AccountID: accountID,
+		Type:      PenaltyType(r[pType]),
+		Start:     start,
+		End:       end,
+		Reason:    r[pReason],
+		Appeal:    Appeal(r[pAppeal]),
+	}, nil
+}
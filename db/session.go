@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Session is a signed in browser.
+// Only the SHA-256 hash of the session token is stored.
+type Session struct {
+	ID        int
+	TokenHash string
+	Username  string
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+	// Confirmed is the last time the password was entered,
+	// at sign in or to confirm a sensitive action.
+	Confirmed time.Time
+	// Pending sessions wait for the second factor of the user.
+	Pending bool
+}
+
+func (d *DB) AddSession(s *Session) error {
+	id, err := d.sessions.add(sessionToRecord(s))
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	return nil
+}
+
+// Session returns sql.ErrNoRows if there is no session with tokenHash.
+func (d *DB) Session(tokenHash string) (*Session, error) {
+	records, err := d.sessions.all()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r[seTokenHash] == tokenHash {
+			return recordToSession(r)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// Sessions returns the sessions of username, the most recently active first.
+func (d *DB) Sessions(username string) ([]*Session, error) {
+	records, err := d.sessions.all()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0)
+	for _, r := range records {
+		if r[seUsername] != username {
+			continue
+		}
+		s, err := recordToSession(r)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// TouchSession sets the last activity of a session.
+func (d *DB) TouchSession(tokenHash string, t time.Time) error {
+	return d.sessions.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[seTokenHash] == tokenHash {
+				records[i][seLastSeen] = t.Format(timeFormat)
+				return records, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// ConfirmSession sets the time the password was last confirmed.
+func (d *DB) ConfirmSession(tokenHash string, t time.Time) error {
+	return d.sessions.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[seTokenHash] == tokenHash {
+				records[i][seConfirmed] = t.Format(timeFormat)
+				return records, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// VerifySession marks a pending session as signed in.
+func (d *DB) VerifySession(tokenHash string, t time.Time) error {
+	return d.sessions.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[seTokenHash] == tokenHash {
+				records[i][sePending] = "false"
+				records[i][seLastSeen] = t.Format(timeFormat)
+				records[i][seConfirmed] = t.Format(timeFormat)
+				return records, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+func (d *DB) RemoveSession(tokenHash string) error {
+	return d.removeSessions(func(r []string) bool {
+		return r[seTokenHash] == tokenHash
+	})
+}
+
+// RemoveSessions signs out every session of username.
+func (d *DB) RemoveSessions(username string) error {
+	return d.removeSessions(func(r []string) bool {
+		return r[seUsername] == username
+	})
+}
+
+func (d *DB) removeSessions(remove func([]string) bool) error {
+	return d.sessions.update(func(records [][]string) ([][]string, error) {
+		kept := records[:0]
+		for _, r := range records {
+			if !remove(r) {
+				kept = append(kept, r)
+			}
+		}
+		return kept, nil
+	})
+}
+
+const (
+	seID        = 0
+	seTokenHash = 1
+	seUsername  = 2
+	seCreated   = 3
+	seLastSeen  = 4
+	seIP        = 5
+	seUserAgent = 6
+	seConfirmed = 7
+	sePending   = 8
+	seLen       = 9
+)
+
+func padSession(r []string) []string {
+	for len(r) < seLen {
+		r = append(r, "")
+	}
+	return r
+}
+
+func sessionToRecord(s *Session) []string {
+	r := make([]string, seLen)
+	r[seID] = strconv.Itoa(s.ID)
+	r[seTokenHash] = s.TokenHash
+	r[seUsername] = s.Username
+	r[seCreated] = s.Created.Format(timeFormat)
+	r[seLastSeen] = s.LastSeen.Format(timeFormat)
+	r[seIP] = s.IP
+	r[seUserAgent] = s.UserAgent
+	r[seConfirmed] = s.Confirmed.Format(timeFormat)
+	r[sePending] = strconv.FormatBool(s.Pending)
+	return r
+}
+
+func recordToSession(r []string) (*Session, error) {
+	id, err := strconv.Atoi(r[seID])
+	if err != nil {
+		return nil, err
+	}
+	created, err := time.Parse(timeFormat, r[seCreated])
+	if err != nil {
+		return nil, err
+	}
+	lastSeen, err := time.Parse(timeFormat, r[seLastSeen])
+	if err != nil {
+		return nil, err
+	}
+	// Sessions from before the column was added were never confirmed.
+	var confirmed time.Time
+	if r[seConfirmed] != "" {
+		confirmed, err = time.Parse(timeFormat, r[seConfirmed])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Session{
+		ID:        id,
+		TokenHash: r[seTokenHash],
+		Username:  r[seUsername],
+		Created:   created,
+		LastSeen:  lastSeen,
+		IP:        r[seIP],
+		UserAgent: r[seUserAgent],
+		Confirmed: confirmed,
+		Pending:   r[sePending] == "true",
+	}, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// Settings can be changed by admins while the server runs.
+const (
+	// SettingRequireTwoFactor is "true" if every user
+	// has to sign in with a second factor.
+	SettingRequireTwoFactor = "require_2fa"
+)
+
+// Setting returns sql.ErrNoRows if key was never set.
+func (d *DB) Setting(key string) (string, error) {
+	records, err := d.settings.all()
+	if err != nil {
+		return "", err
+	}
+	for _, r := range records {
+		if r[stKey] == key {
+			return r[stValue], nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (d *DB) SetSetting(key, value string) error {
+	t := d.settings
+	return t.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[stKey] == key {
+				records[i][stValue] = value
+				return records, nil
+			}
+		}
+		// update holds the lock of t.
+		r := make([]string, stLen)
+		r[stID] = strconv.Itoa(t.ctr)
+		r[stKey] = key
+		r[stValue] = value
+		t.ctr++
+		return append(records, r), nil
+	})
+}
+
+const (
+	stID    = 0
+	stKey   = 1
+	stValue = 2
+	stLen   = 3
+)
+
+func padSetting(r []string) []string {
+	for len(r) < stLen {
+		r = append(r, "")
+	}
+	return r
+}
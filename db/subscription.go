@@ -0,0 +1,123 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Subscription holds the email address of a user
+// and the topics the user gets mails about.
+type Subscription struct {
+	ID       int
+	Username string
+	Email    string
+	Topics   []string
+}
+
+// Has reports whether s includes topic.
+func (s Subscription) Has(topic string) bool {
+	for _, t := range s.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DB) Subscriptions() ([]*Subscription, error) {
+	records, err := d.subscriptions.all()
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*Subscription, 0)
+	for _, r := range records {
+		s, err := recordToSubscription(r)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, nil
+}
+
+// Subscription returns sql.ErrNoRows if username has no subscription.
+func (d *DB) Subscription(username string) (*Subscription, error) {
+	subs, err := d.Subscriptions()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subs {
+		if s.Username == username {
+			return s, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// SetSubscription replaces the subscription of s.Username.
+func (d *DB) SetSubscription(s *Subscription) error {
+	t := d.subscriptions
+	return t.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[sUsername] == s.Username {
+				s.ID, _ = strconv.Atoi(r[sID])
+				records[i] = subscriptionToRecord(s)
+				return records, nil
+			}
+		}
+		// update holds the lock of t.
+		s.ID = t.ctr
+		t.ctr++
+		return append(records, subscriptionToRecord(s)), nil
+	})
+}
+
+// RemoveSubscription removes the subscription of username if there is one.
+func (d *DB) RemoveSubscription(username string) error {
+	return d.subscriptions.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[sUsername] == username {
+				return append(records[:i], records[i+1:]...), nil
+			}
+		}
+		return records, nil
+	})
+}
+
+const (
+	sID       = 0
+	sUsername = 1
+	sEmail    = 2
+	sTopics   = 3
+	sLen      = 4
+)
+
+func padSubscription(r []string) []string {
+	for len(r) < sLen {
+		r = append(r, "")
+	}
+	return r
+}
+
+func subscriptionToRecord(s *Subscription) []string {
+	r := make([]string, sLen)
+	r[sID] = strconv.Itoa(s.ID)
+	r[sUsername] = s.Username
+	r[sEmail] = s.Email
+	r[sTopics] = strings.Join(s.Topics, " ")
+	return r
+}
+
+func recordToSubscription(r []string) (*Subscription, error) {
+	id, err := strconv.Atoi(r[sID])
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{
+		ID:       id,
+		Username: r[sUsername],
+		Email:    r[sEmail],
+		Topics:   strings.Fields(r[sTopics]),
+	}, nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// table is a csv file with one record per line.
+// The first column of every record is a unique integer id.
+type table struct {
+	dir, name string
+	// migrate is called for every record with less columns
+	// than the current version writes.
+	migrate func([]string) []string
+	sync.RWMutex
+	*os.File
+	ctr int
+}
+
+func openTable(dir, name string, migrate func([]string) []string) (*table, error) {
+	t := &table{dir: dir, name: name, migrate: migrate}
+
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+
+	records, err := t.all()
+	if err != nil {
+		t.File.Close()
+		return nil, err
+	}
+	for _, r := range records {
+		id, err := strconv.Atoi(r[0])
+		if err != nil {
+			t.File.Close()
+			return nil, err
+		}
+		if id > t.ctr {
+			t.ctr = id
+		}
+	}
+	t.ctr++
+
+	return t, nil
+}
+
+func (t *table) open() error {
+	f, err := os.OpenFile(
+		filepath.Join(t.dir, t.name),
+		os.O_RDWR|os.O_CREATE|os.O_SYNC,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+	t.File = f
+	return nil
+}
+
+func (t *table) close() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.File.Close()
+}
+
+func (t *table) all() ([][]string, error) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.allUnsync()
+}
+
+func (t *table) allUnsync() ([][]string, error) {
+	if _, err := t.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(t)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, rec := range records {
+		records[i] = t.migrate(rec)
+	}
+	return records, nil
+}
+
+// add appends record with a new id and returns the id.
+func (t *table) add(record []string) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	id := t.ctr
+	t.ctr++
+	record[0] = strconv.Itoa(id)
+
+	if _, err := t.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	w := csv.NewWriter(t)
+	if err := w.Write(record); err != nil {
+		return 0, err
+	}
+	w.Flush()
+	return id, w.Error()
+}
+
+func (t *table) update(f func([][]string) ([][]string, error)) error {
+	t.Lock()
+	defer t.Unlock()
+
+	records, err := t.allUnsync()
+	if err != nil {
+		return err
+	}
+	records, err = f(records)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(t.dir, t.name)
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+
+	wErr := csv.NewWriter(tmp).WriteAll(records)
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if wErr != nil {
+		return wErr
+	}
+
+	if err := os.Rename(name, filepath.Join(t.dir, t.name)); err != nil {
+		return err
+	}
+	t.File.Close()
+	return t.open()
+}
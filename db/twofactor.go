@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// TwoFactor is the TOTP enrollment of a user.
+type TwoFactor struct {
+	ID       int
+	Username string
+	Secret   string
+	// Enabled is set once a code of Secret was entered.
+	Enabled bool
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes.
+	RecoveryCodes []string
+	// LastCounter is the time step of the last accepted code,
+	// codes can't be used twice.
+	LastCounter int64
+}
+
+// TwoFactor returns sql.ErrNoRows if username isn't enrolled.
+func (d *DB) TwoFactor(username string) (*TwoFactor, error) {
+	records, err := d.twoFactor.all()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r[tfUsername] == username {
+			return recordToTwoFactor(r)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// SetTwoFactor replaces the enrollment of tf.Username.
+func (d *DB) SetTwoFactor(tf *TwoFactor) error {
+	t := d.twoFactor
+	return t.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[tfUsername] == tf.Username {
+				tf.ID, _ = strconv.Atoi(r[tfID])
+				records[i] = twoFactorToRecord(tf)
+				return records, nil
+			}
+		}
+		// update holds the lock of t.
+		tf.ID = t.ctr
+		t.ctr++
+		return append(records, twoFactorToRecord(tf)), nil
+	})
+}
+
+func (d *DB) RemoveTwoFactor(username string) error {
+	return d.twoFactor.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[tfUsername] == username {
+				return append(records[:i], records[i+1:]...), nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+// UseTwoFactorCounter sets the last time step of username to counter
+// and reports whether it is after the previous one.
+func (d *DB) UseTwoFactorCounter(username string, counter int64) (bool, error) {
+	used := false
+	err := d.twoFactor.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[tfUsername] != username {
+				continue
+			}
+			tf, err := recordToTwoFactor(r)
+			if err != nil {
+				return nil, err
+			}
+			if counter <= tf.LastCounter {
+				return records, nil
+			}
+			records[i][tfLastCounter] = strconv.FormatInt(counter, 10)
+			used = true
+			return records, nil
+		}
+		return nil, sql.ErrNoRows
+	})
+	return used, err
+}
+
+// UseRecoveryCode removes the recovery code with hash of username
+// and reports whether it existed.
+func (d *DB) UseRecoveryCode(username, hash string) (bool, error) {
+	used := false
+	err := d.twoFactor.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[tfUsername] != username {
+				continue
+			}
+			kept := make([]string, 0)
+			for _, c := range strings.Fields(r[tfRecoveryCodes]) {
+				if c == hash && !used {
+					used = true
+					continue
+				}
+				kept = append(kept, c)
+			}
+			records[i][tfRecoveryCodes] = strings.Join(kept, " ")
+			return records, nil
+		}
+		return nil, sql.ErrNoRows
+	})
+	return used, err
+}
+
+const (
+	tfID            = 0
+	tfUsername      = 1
+	tfSecret        = 2
+	tfEnabled       = 3
+	tfRecoveryCodes = 4
+	tfLastCounter   = 5
+	tfLen           = 6
+)
+
+func padTwoFactor(r []string) []string {
+	for len(r) < tfLen {
+		r = append(r, "")
+	}
+	return r
+}
+
+func twoFactorToRecord(tf *TwoFactor) []string {
+	r := make([]string, tfLen)
+	r[tfID] = strconv.Itoa(tf.ID)
+	r[tfUsername] = tf.Username
+	r[tfSecret] = tf.Secret
+	r[tfEnabled] = strconv.FormatBool(tf.Enabled)
+	r[tfRecoveryCodes] = strings.Join(tf.RecoveryCodes, " ")
+	r[tfLastCounter] = strconv.FormatInt(tf.LastCounter, 10)
+	return r
+}
+
+func recordToTwoFactor(r []string) (*TwoFactor, error) {
+	id, err := strconv.Atoi(r[tfID])
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := strconv.ParseBool(r[tfEnabled])
+	if err != nil {
+		return nil, err
+	}
+	var last int64
+	if r[tfLastCounter] != "" {
+		last, err = strconv.ParseInt(r[tfLastCounter], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &TwoFactor{
+		ID:            id,
+		Username:      r[tfUsername],
+		Secret:        r[tfSecret],
+		Enabled:       enabled,
+		RecoveryCodes: strings.Fields(r[tfRecoveryCodes]),
+		LastCounter:   last,
+	}, nil
+}
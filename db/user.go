@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrUserExists is returned by AddUser if the username is taken.
+var ErrUserExists = errors.New("db: username already exists")
+
+const (
+	// RoleViewer can see accounts and check them out.
+	RoleViewer = "viewer"
+	// RoleEditor can also add and edit accounts.
+	RoleEditor = "editor"
+	// RoleAdmin can also remove accounts and manage users.
+	RoleAdmin = "admin"
+)
+
+// Roles are ordered by their permissions, each role can do
+// everything the roles before it can.
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// User can sign in with the bcrypt hashed Password.
+type User struct {
+	ID       int
+	Username string
+	Password string
+	// Disabled users can't sign in.
+	Disabled bool
+	Created  time.Time
+	Role     string
+}
+
+func (d *DB) Users() ([]*User, error) {
+	records, err := d.users.all()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*User, 0)
+	for _, r := range records {
+		u, err := recordToUser(r)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// User returns sql.ErrNoRows if there is no user with username.
+func (d *DB) User(username string) (*User, error) {
+	records, err := d.users.all()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r[uUsername] == username {
+			return recordToUser(r)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// AddUser returns ErrUserExists if u.Username is taken.
+func (d *DB) AddUser(u *User) error {
+	t := d.users
+	return t.update(func(records [][]string) ([][]string, error) {
+		for _, r := range records {
+			if r[uUsername] == u.Username {
+				return nil, ErrUserExists
+			}
+		}
+		// update holds the lock of t.
+		u.ID = t.ctr
+		t.ctr++
+		return append(records, userToRecord(u)), nil
+	})
+}
+
+// SetPassword sets the bcrypt hash of the password of username.
+func (d *DB) SetPassword(username, hash string) error {
+	return d.editUser(username, func(r []string) {
+		r[uPassword] = hash
+	})
+}
+
+func (d *DB) SetUserDisabled(username string, disabled bool) error {
+	return d.editUser(username, func(r []string) {
+		r[uDisabled] = strconv.FormatBool(disabled)
+	})
+}
+
+func (d *DB) SetUserRole(username, role string) error {
+	return d.editUser(username, func(r []string) {
+		r[uRole] = role
+	})
+}
+
+func (d *DB) editUser(username string, edit func([]string)) error {
+	return d.users.update(func(records [][]string) ([][]string, error) {
+		for _, r := range records {
+			if r[uUsername] == username {
+				edit(r)
+				return records, nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+func (d *DB) RemoveUser(username string) error {
+	return d.users.update(func(records [][]string) ([][]string, error) {
+		for i, r := range records {
+			if r[uUsername] == username {
+				return append(records[:i], records[i+1:]...), nil
+			}
+		}
+		return nil, sql.ErrNoRows
+	})
+}
+
+const (
+	uID       = 0
+	uUsername = 1
+	uPassword = 2
+	uDisabled = 3
+	uCreated  = 4
+	uRole     = 5
+	uLen      = 6
+)
+
+func padUser(r []string) []string {
+	n := len(r)
+	for len(r) < uLen {
+		r = append(r, "")
+	}
+	if n <= uRole {
+		// Every user could do everything before there were roles.
+		r[uRole] = RoleAdmin
+	}
+	return r
+}
+
+func userToRecord(u *User) []string {
+	r := make([]string, uLen)
+	r[uID] = strconv.Itoa(u.ID)
+	r[uUsername] = u.Username
+	r[uPassword] = u.Password
+	r[uDisabled] = strconv.FormatBool(u.Disabled)
+	r[uCreated] = u.Created.Format(timeFormat)
+	r[uRole] = u.Role
+	return r
+}
+
+func recordToUser(r []string) (*User, error) {
+	id, err := strconv.Atoi(r[uID])
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := strconv.ParseBool(r[uDisabled])
+	if err != nil {
+		return nil, err
+	}
+	created, err := time.Parse(timeFormat, r[uCreated])
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		ID:       id,
+		Username: r[uUsername],
+		Password: r[uPassword],
+		Disabled: disabled,
+		Created:  created,
+		Role:     r[uRole],
+	}, nil
+}
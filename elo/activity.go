@@ -0,0 +1,63 @@
+package elo
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// RecentWindow is the time span in which games count as recent.
+const RecentWindow = 14 * 24 * time.Hour
+
+type Activity struct {
+	// LastPlayed is zero if the account never played.
+	LastPlayed  time.Time
+	RecentGames int
+}
+
+// Activity looks up when the player with the given PUUID last played
+// and how many games were played in the RecentWindow before now.
+// Only ranked solo/duo games count, other games don't prevent decay.
+func (api *RiotAPI) Activity(ctx context.Context, region, puuid string, now time.Time) (*Activity, error) {
+	recent, err := api.MatchIDs(ctx, region, puuid, now.Add(-RecentWindow), QueueRankedSolo, "", 100)
+	if err != nil {
+		return nil, err
+	}
+	a := &Activity{RecentGames: len(recent)}
+
+	last := recent
+	if len(last) == 0 {
+		last, err = api.MatchIDs(ctx, region, puuid, time.Time{}, QueueRankedSolo, "", 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(last) == 0 {
+			return a, nil
+		}
+	}
+	m, err := api.Match(ctx, region, last[0])
+	if err != nil {
+		return nil, err
+	}
+	a.LastPlayed = m.End()
+	return a, nil
+}
+
+// Ranks of Diamond and above decay after this long without ranked games.
+const decayAfter = 28 * 24 * time.Hour
+
+// DecayIn returns the time left until rank starts to decay
+// if the account isn't played after lastPlayed.
+// ok is false for ranks that don't decay.
+func DecayIn(rank string, lastPlayed, now time.Time) (d time.Duration, ok bool) {
+	fields := strings.Fields(rank)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	switch strings.ToLower(fields[0]) {
+	case "diamond", "master", "grandmaster", "challenger":
+	default:
+		return 0, false
+	}
+	return lastPlayed.Add(decayAfter).Sub(now), true
+}
@@ -0,0 +1,126 @@
+package elo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// matchTypes are the types of the queue ids in testdata/match_*.json.
+var matchTypes = map[int]string{
+	420: MatchRanked,
+	430: MatchNormal,
+	450: MatchNormal,
+}
+
+// matchServer serves the matches in testdata/match_*.json
+// like the match endpoints of the Riot API.
+func matchServer(t *testing.T) *httptest.Server {
+	files, err := filepath.Glob(filepath.Join("testdata", "match_*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type match struct {
+		Metadata struct {
+			MatchID string `json:"matchId"`
+		} `json:"metadata"`
+		Info struct {
+			GameCreation int64 `json:"gameCreation"`
+			QueueID      int   `json:"queueId"`
+		} `json:"info"`
+	}
+	matches := make([]match, 0)
+	bodies := make(map[string][]byte)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m match
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		matches = append(matches, m)
+		bodies[m.Metadata.MatchID] = b
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Info.GameCreation > matches[j].Info.GameCreation
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/ids") {
+			q := r.URL.Query()
+			queue, _ := strconv.Atoi(q.Get("queue"))
+			typ := q.Get("type")
+			start, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+			count, _ := strconv.Atoi(q.Get("count"))
+			ids := make([]string, 0)
+			for _, m := range matches {
+				if (queue == 0 || m.Info.QueueID == queue) && (typ == "" || matchTypes[m.Info.QueueID] == typ) && m.Info.GameCreation/1000 >= start {
+					ids = append(ids, m.Metadata.MatchID)
+				}
+			}
+			if len(ids) > count {
+				ids = ids[:count]
+			}
+			json.NewEncoder(w).Encode(ids)
+			return
+		}
+		b, ok := bodies[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+}
+
+func TestActivity(t *testing.T) {
+	srv := matchServer(t)
+	defer srv.Close()
+	api := &RiotAPI{Client: srv.Client(), BaseURL: srv.URL}
+
+	// The ARAM game a day ago doesn't count,
+	// the ranked game 20 days ago is the last one.
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	a, err := api.Activity(context.Background(), "euw", "puuid", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2019, 4, 25, 16, 25, 0, 0, time.UTC)
+	if a.RecentGames != 0 || !a.LastPlayed.Equal(want) {
+		t.Fatalf("expected no recent games and last played at %v, got %+v", want, a)
+	}
+}
+
+func TestDecayIn(t *testing.T) {
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		rank       string
+		lastPlayed time.Time
+		want       time.Duration
+		ok         bool
+	}{
+		{"Gold II", now.Add(-100 * day), 0, false},
+		{"Unranked", now, 0, false},
+		{"", now, 0, false},
+		{"Diamond IV", now.Add(-20 * day), 8 * day, true},
+		{"Master", now.Add(-30 * day), -2 * day, true},
+		{"Challenger", now, 28 * day, true},
+	}
+	for _, tt := range tests {
+		d, ok := DecayIn(tt.rank, tt.lastPlayed, now)
+		if d != tt.want || ok != tt.ok {
+			t.Errorf("DecayIn(%q, %v) = %v, %t, expected %v, %t", tt.rank, tt.lastPlayed, d, ok, tt.want, tt.ok)
+		}
+	}
+}
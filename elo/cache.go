@@ -0,0 +1,137 @@
+package elo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache stores the responses of rank providers on disk.
+// Responses older than TTL are revalidated with the provider
+// using ETag and Last-Modified.
+type Cache struct {
+	Dir string
+	TTL time.Duration
+}
+
+type cacheEntry struct {
+	Fetched      time.Time
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+// fetch returns the body of a successful GET request to url.
+// A 404 response results in ErrNotFound.
+// If c is nil, every call makes a request.
+// If force is set, a cached response is revalidated even if it isn't older than TTL.
+func (c *Cache) fetch(ctx context.Context, client *http.Client, provider, url string, force bool) ([]byte, error) {
+	var path string
+	var e *cacheEntry
+	if c != nil {
+		path = c.path(provider, url)
+		var err error
+		if e, err = c.load(path); err != nil {
+			return nil, err
+		}
+		if e != nil && !force && time.Since(e.Fetched) < c.TTL {
+			return e.Body, nil
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if e != nil {
+		if e.ETag != "" {
+			req.Header.Set("If-None-Match", e.ETag)
+		}
+		if e.LastModified != "" {
+			req.Header.Set("If-Modified-Since", e.LastModified)
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening URL: %s, %v", url, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && e != nil:
+		e.Fetched = time.Now()
+		return e.Body, c.store(path, e)
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s returned %s", url, res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading response of %s, %v", url, err)
+	}
+	if c == nil {
+		return body, nil
+	}
+	return body, c.store(path, &cacheEntry{
+		Fetched:      time.Now(),
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Body:         body,
+	})
+}
+
+func (c *Cache) path(provider, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, provider, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns nil if there is no entry at path.
+func (c *Cache) load(path string) (*cacheEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := new(cacheEntry)
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("cache: failed decoding %s, %v", path, err)
+	}
+	return e, nil
+}
+
+func (c *Cache) store(path string, e *cacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, wErr := tmp.Write(b)
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if wErr != nil {
+		return wErr
+	}
+	return os.Rename(tmp.Name(), path)
+}
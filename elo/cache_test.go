@@ -0,0 +1,73 @@
+package elo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	etag, body := `"v1"`, "first"
+	requests, conditional := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") != "" {
+			conditional++
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	c := &Cache{Dir: dir, TTL: time.Hour}
+	fetch := func(force bool, want string, wantRequests, wantConditional int) {
+		t.Helper()
+		b, err := c.fetch(context.Background(), srv.Client(), "test", srv.URL+"/profile", force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("expected body %q, got %q", want, b)
+		}
+		if requests != wantRequests || conditional != wantConditional {
+			t.Fatalf("expected %d requests (%d conditional), got %d (%d)",
+				wantRequests, wantConditional, requests, conditional)
+		}
+	}
+	get := func(want string, wantRequests, wantConditional int) {
+		t.Helper()
+		fetch(false, want, wantRequests, wantConditional)
+	}
+
+	get("first", 1, 0)
+	get("first", 1, 0)
+
+	c.TTL = 0
+	get("first", 2, 1)
+
+	etag, body = `"v2"`, "second"
+	get("second", 3, 2)
+
+	c = &Cache{Dir: dir, TTL: time.Hour}
+	get("second", 3, 2)
+
+	// Forced fetches skip the TTL, but are still conditional.
+	fetch(true, "second", 4, 3)
+	etag, body = `"v3"`, "third"
+	fetch(true, "third", 5, 4)
+	get("third", 5, 4)
+}
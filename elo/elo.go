@@ -1,76 +1,231 @@
 package elo
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/erikfastermann/lam/db"
-	"github.com/erikfastermann/lam/handler"
 	"golang.org/x/net/html"
 )
 
 var ErrNotFound = errors.New("account not found")
 
-func UpdateAll(db *db.DB) error {
-	accs, err := db.Accounts()
-	if err != nil {
-		return fmt.Errorf("failed reading accounts from database, %v", err)
-	}
-	for _, acc := range accs {
-		time.Sleep(time.Second)
-		elo, err := Get(acc.Region, acc.IGN)
-		if err != nil {
-			if err == ErrNotFound {
-				continue
-			}
-			return err
-		}
+// Profile is the information found on the profile page of an account.
+type Profile struct {
+	// Ranks contains every queue the provider knows about,
+	// unranked queues are set to "Unranked".
+	Ranks map[db.Queue]string
+	// Level is the summoner level, 0 if it wasn't found.
+	Level int
+	// Activity is nil if the provider doesn't know about matches.
+	Activity *Activity
+}
 
-		if err := db.EditElo(acc.ID, elo); err != nil {
-			return fmt.Errorf("couldn't update elo in database (Account-ID: %d), %v", acc.ID, err)
-		}
-	}
-	return nil
+const leagueOfGraphsURL = "https://www.leagueofgraphs.com"
+
+// LeagueOfGraphs looks up profiles on leagueofgraphs.com.
+type LeagueOfGraphs struct {
+	Client *http.Client
+	// BaseURL replaces https://www.leagueofgraphs.com if it isn't empty.
+	BaseURL string
+	// Cache may be nil.
+	Cache *Cache
 }
 
-func Get(region, ign string) (string, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	url := handler.LeagueOfGraphsURL(region, ign)
+var defaultLeagueOfGraphs = &LeagueOfGraphs{
+	Client: &http.Client{Timeout: 10 * time.Second},
+}
+
+// Get looks up the profile of the account with the Riot ID name#tagLine
+// using the default LeagueOfGraphs.
+func Get(ctx context.Context, region, name, tagLine string) (*Profile, error) {
+	return defaultLeagueOfGraphs.Get(ctx, region, name, tagLine)
+}
+
+// LeagueOfGraphsURL returns the profile URL on leagueofgraphs.com.
+func LeagueOfGraphsURL(region, name, tagLine string) string {
+	return defaultLeagueOfGraphs.URL(region, name, tagLine)
+}
+
+// Get looks up the profile of the account with the Riot ID name#tagLine.
+// If tagLine is empty, the default tag line of the region is used.
+func (l *LeagueOfGraphs) Get(ctx context.Context, region, name, tagLine string) (*Profile, error) {
+	return l.get(ctx, region, name, tagLine, false)
+}
+
+// get is Get, force skips the TTL of the cache.
+func (l *LeagueOfGraphs) get(ctx context.Context, region, name, tagLine string, force bool) (*Profile, error) {
+	url := l.URL(region, name, tagLine)
 	if url == "" {
-		return "", ErrNotFound
+		return nil, ErrNotFound
 	}
-	res, err := client.Get(url)
+	body, err := l.Cache.fetch(ctx, l.Client, "leagueofgraphs", url, force)
 	if err != nil {
-		return "", fmt.Errorf("failed opening URL: %s, %v", url, err)
+		return nil, err
+	}
+	return parseProfile(bytes.NewReader(body))
+}
+
+func (l *LeagueOfGraphs) URL(region, name, tagLine string) string {
+	if tagLine == "" {
+		tagLine = DefaultTagLine(region)
 	}
-	if res.StatusCode == 404 {
-		return "", ErrNotFound
+	if region == "" || name == "" || tagLine == "" {
+		return ""
 	}
-	defer res.Body.Close()
+	base := l.BaseURL
+	if base == "" {
+		base = leagueOfGraphsURL
+	}
+	return fmt.Sprintf("%s/en/summoner/%s/%s-%s",
+		base,
+		url.PathEscape(region),
+		url.PathEscape(name),
+		url.PathEscape(tagLine),
+	)
+}
+
+var errStructure = errors.New("parsing error, structure changed")
+
+const unranked = "Unranked"
 
-	z := html.NewTokenizer(res.Body)
+// parseProfile reads the rank of every league queue on the page.
+// Each leagueTier is followed by an element of class queue naming the queue,
+// a tier without a name is the solo queue.
+func parseProfile(r io.Reader) (*Profile, error) {
+	p := &Profile{Ranks: make(map[db.Queue]string)}
+	var tier string
+	var foundBanner, complete bool
+	z := html.NewTokenizer(r)
 	for {
 		tt := z.Next()
 		switch tt {
 		case html.ErrorToken:
-			return "", fmt.Errorf("parsing error, %v", z.Err())
+			if err := z.Err(); err != io.EOF {
+				return nil, fmt.Errorf("parsing error, %v", err)
+			}
+			switch {
+			case !complete:
+				return nil, fmt.Errorf("parsing error, %v", io.ErrUnexpectedEOF)
+			case !foundBanner && len(p.Ranks) == 0 && tier == "":
+				return nil, errStructure
+			}
+			if tier != "" {
+				if _, ok := p.Ranks[db.QueueSolo]; !ok {
+					p.Ranks[db.QueueSolo] = tier
+				}
+			}
+			for _, q := range []db.Queue{db.QueueSolo, db.QueueFlex} {
+				if _, ok := p.Ranks[q]; !ok {
+					p.Ranks[q] = unranked
+				}
+			}
+			return p, nil
+		case html.EndTagToken:
+			if z.Token().Data == "html" {
+				complete = true
+			}
 		case html.StartTagToken:
 			t := z.Token()
-			if t.Data == "div" || t.Data == "span" {
-				for _, attr := range t.Attr {
-					if attr.Key == "class" && attr.Val == "leagueTier" {
-						if tt := z.Next(); tt != html.TextToken {
-							return "", errors.New("parsing error, structure changed")
-						}
-						return strings.TrimSpace(z.Token().Data), nil
+			if t.Data != "div" && t.Data != "span" {
+				continue
+			}
+			switch {
+			case hasClass(t, "leagueTier"):
+				if tt := z.Next(); tt != html.TextToken {
+					return nil, errStructure
+				}
+				if tier != "" {
+					if _, ok := p.Ranks[db.QueueSolo]; !ok {
+						p.Ranks[db.QueueSolo] = tier
 					}
 				}
+				tier = strings.TrimSpace(z.Token().Data)
+			case hasClass(t, "queue"):
+				if tt := z.Next(); tt != html.TextToken || tier == "" {
+					continue
+				}
+				if q, ok := parseQueue(z.Token().Data); ok {
+					p.Ranks[q] = tier
+					tier = ""
+				}
+			case hasClass(t, "bannerSubtitle"):
+				foundBanner = true
+				if tt := z.Next(); tt != html.TextToken {
+					continue
+				}
+				p.Level = parseLevel(z.Token().Data)
+			}
+		}
+	}
+}
+
+func parseQueue(s string) (db.Queue, bool) {
+	s = strings.ToLower(s)
+	switch {
+	case strings.Contains(s, "solo"):
+		return db.QueueSolo, true
+	case strings.Contains(s, "flex"):
+		return db.QueueFlex, true
+	default:
+		return "", false
+	}
+}
+
+func hasClass(t html.Token, class string) bool {
+	for _, attr := range t.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, c := range strings.Fields(attr.Val) {
+			if c == class {
+				return true
 			}
 		}
 	}
+	return false
+}
+
+// parseLevel parses texts like "Level 42 - ...", it returns 0 on failure.
+func parseLevel(s string) int {
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] != "Level" {
+			continue
+		}
+		if level, err := strconv.Atoi(fields[i+1]); err == nil {
+			return level
+		}
+	}
+	return 0
+}
+
+// Tag lines Riot assigned to summoner names
+// that were migrated to Riot IDs without being changed.
+var defaultTagLines = map[string]string{
+	"br":   "BR1",
+	"eune": "EUNE",
+	"euw":  "EUW",
+	"lan":  "LAN",
+	"las":  "LAS",
+	"na":   "NA1",
+	"oce":  "OCE",
+	"ru":   "RU1",
+	"tr":   "TR1",
+	"jp":   "JP1",
+	"sea":  "SG2",
+	"kr":   "KR1",
+	"pbe":  "PBE",
+}
+
+func DefaultTagLine(region string) string {
+	return defaultTagLines[region]
 }
@@ -0,0 +1,106 @@
+package elo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func TestLeagueOfGraphsGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		status  int
+		// cut truncates the body while the header announces the whole fixture.
+		cut     int
+		want    *Profile
+		wantErr error
+	}{
+		{name: "ranked", fixture: "ranked.html", status: 200, want: &Profile{Ranks: map[db.Queue]string{db.QueueSolo: "Gold II", db.QueueFlex: "Platinum IV"}, Level: 187}},
+		{name: "unranked", fixture: "unranked.html", status: 200, want: &Profile{Ranks: map[db.Queue]string{db.QueueSolo: unranked, db.QueueFlex: unranked}, Level: 23}},
+		{name: "notfound", fixture: "notfound.html", status: 404, wantErr: ErrNotFound},
+		{name: "changed", fixture: "changed.html", status: 200, wantErr: errStructure},
+		{name: "truncated", fixture: "truncated.html", status: 200},
+		{name: "dropped", fixture: "ranked.html", status: 200, cut: 400},
+		{name: "unavailable", fixture: "notfound.html", status: 503},
+	}
+
+	fixtures := make(map[string][]byte)
+	for _, tt := range tests {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[tt.fixture] = b
+	}
+
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		for _, tt := range tests {
+			if r.URL.Path != "/en/summoner/euw/"+tt.name+"-EUW" {
+				continue
+			}
+			b := fixtures[tt.fixture]
+			if tt.cut > 0 {
+				w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+				b = b[:tt.cut]
+			}
+			w.WriteHeader(tt.status)
+			w.Write(b)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	lg := &LeagueOfGraphs{Client: srv.Client(), BaseURL: srv.URL}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := lg.Get(context.Background(), "euw", tt.name, "")
+			if want := "/en/summoner/euw/" + tt.name + "-EUW"; path != want {
+				t.Fatalf("requested %s, expected %s", path, want)
+			}
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", p)
+				}
+				if tt.wantErr != nil && err != tt.wantErr {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, p)
+			}
+		})
+	}
+}
+
+func TestLeagueOfGraphsURL(t *testing.T) {
+	tests := []struct {
+		region, name, tagLine string
+		want                  string
+	}{
+		{"euw", "player0", "", "https://www.leagueofgraphs.com/en/summoner/euw/player0-EUW"},
+		{"na", "player 1", "1234", "https://www.leagueofgraphs.com/en/summoner/na/player%201-1234"},
+		{"cn", "player2", "", ""},
+		{"euw", "", "EUW", ""},
+	}
+	for _, tt := range tests {
+		if got := LeagueOfGraphsURL(tt.region, tt.name, tt.tagLine); got != tt.want {
+			t.Errorf("LeagueOfGraphsURL(%q, %q, %q) = %q, expected %q",
+				tt.region, tt.name, tt.tagLine, got, tt.want)
+		}
+	}
+}
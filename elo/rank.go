@@ -0,0 +1,61 @@
+package elo
+
+import (
+	"strings"
+)
+
+var tiers = []string{
+	"iron",
+	"bronze",
+	"silver",
+	"gold",
+	"platinum",
+	"emerald",
+	"diamond",
+	"master",
+	"grandmaster",
+	"challenger",
+}
+
+var divisions = map[string]int{"IV": 0, "III": 1, "II": 2, "I": 3}
+
+// rankValue maps ranks like "Gold II" to comparable numbers.
+// ok is false for unranked and unknown ranks.
+func rankValue(rank string) (v int, ok bool) {
+	fields := strings.Fields(rank)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	tier := -1
+	for i, t := range tiers {
+		if strings.EqualFold(fields[0], t) {
+			tier = i
+			break
+		}
+	}
+	if tier < 0 {
+		return 0, false
+	}
+	division := 0
+	if len(fields) > 1 {
+		if division, ok = divisions[strings.ToUpper(fields[1])]; !ok {
+			return 0, false
+		}
+	}
+	return tier*len(divisions) + division, true
+}
+
+// compareRanks returns a positive number if rank is higher than old,
+// a negative one if it is lower and 0 if they are equal.
+// ok is false if either of them is unranked.
+func compareRanks(old, rank string) (cmp int, ok bool) {
+	o, ok := rankValue(old)
+	if !ok {
+		return 0, false
+	}
+	r, ok := rankValue(rank)
+	if !ok {
+		return 0, false
+	}
+	return r - o, true
+}
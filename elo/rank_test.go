@@ -0,0 +1,27 @@
+package elo
+
+import "testing"
+
+func TestCompareRanks(t *testing.T) {
+	tests := []struct {
+		old, rank string
+		cmp       int
+		ok        bool
+	}{
+		{"Gold II", "Gold I", 1, true},
+		{"Gold I", "Platinum IV", 1, true},
+		{"Diamond I", "Master", 1, true},
+		{"Master", "Grandmaster", 4, true},
+		{"Emerald IV", "Platinum I", -1, true},
+		{"silver iii", "Silver III", 0, true},
+		{"Unranked", "Gold IV", 0, false},
+		{"", "Gold IV", 0, false},
+		{"Gold V", "Gold IV", 0, false},
+	}
+	for _, tt := range tests {
+		cmp, ok := compareRanks(tt.old, tt.rank)
+		if ok != tt.ok || cmp != tt.cmp {
+			t.Errorf("compareRanks(%q, %q) = %d, %t, expected %d, %t", tt.old, tt.rank, cmp, ok, tt.cmp, tt.ok)
+		}
+	}
+}
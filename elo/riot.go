@@ -0,0 +1,309 @@
+package elo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RiotAPI is a client for the Riot Games API.
+type RiotAPI struct {
+	Key    string
+	Client *http.Client
+	// BaseURL replaces https:// and the host of every request if it isn't empty.
+	BaseURL string
+}
+
+func NewRiotAPI(key string) *RiotAPI {
+	return &RiotAPI{
+		Key:    key,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type RiotAccount struct {
+	PUUID    string `json:"puuid"`
+	GameName string `json:"gameName"`
+	TagLine  string `json:"tagLine"`
+}
+
+func (api *RiotAPI) AccountByRiotID(ctx context.Context, region, name, tagLine string) (*RiotAccount, error) {
+	acc := new(RiotAccount)
+	path := fmt.Sprintf("/riot/account/v1/accounts/by-riot-id/%s/%s",
+		url.PathEscape(name),
+		url.PathEscape(tagLine),
+	)
+	if err := api.get(ctx, accountHost(region), path, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (api *RiotAPI) AccountByPUUID(ctx context.Context, region, puuid string) (*RiotAccount, error) {
+	acc := new(RiotAccount)
+	path := "/riot/account/v1/accounts/by-puuid/" + url.PathEscape(puuid)
+	if err := api.get(ctx, accountHost(region), path, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+type Summoner struct {
+	PUUID         string `json:"puuid"`
+	SummonerLevel int    `json:"summonerLevel"`
+}
+
+func (api *RiotAPI) SummonerByPUUID(ctx context.Context, region, puuid string) (*Summoner, error) {
+	host, ok := platformHosts[region]
+	if !ok {
+		return nil, fmt.Errorf("riot api: unknown region %s", region)
+	}
+	s := new(Summoner)
+	path := "/lol/summoner/v4/summoners/by-puuid/" + url.PathEscape(puuid)
+	if err := api.get(ctx, host, path, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type LeagueEntry struct {
+	QueueType string `json:"queueType"`
+	Tier      string `json:"tier"`
+	Rank      string `json:"rank"`
+}
+
+// String formats the entry like leagueofgraphs does, e.g. "Gold II" or "Master".
+func (e LeagueEntry) String() string {
+	tier := strings.Title(strings.ToLower(e.Tier))
+	switch e.Tier {
+	case "MASTER", "GRANDMASTER", "CHALLENGER":
+		return tier
+	}
+	return tier + " " + e.Rank
+}
+
+// TFTRank returns the ranked TFT rank or "Unranked".
+func (api *RiotAPI) TFTRank(ctx context.Context, region, puuid string) (string, error) {
+	host, ok := platformHosts[region]
+	if !ok {
+		return "", fmt.Errorf("riot api: unknown region %s", region)
+	}
+	var entries []LeagueEntry
+	path := "/tft/league/v1/by-puuid/" + url.PathEscape(puuid)
+	if err := api.get(ctx, host, path, &entries); err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if e.QueueType == "RANKED_TFT" {
+			return e.String(), nil
+		}
+	}
+	return unranked, nil
+}
+
+// Queue ids of matches.
+const (
+	QueueRankedSolo = 420
+	QueueARAM       = 450
+)
+
+// Types of matches, custom games and the practice tool have none.
+const (
+	MatchRanked = "ranked"
+	MatchNormal = "normal"
+)
+
+// MatchIDs returns the ids of up to count matches, newest first.
+// If since isn't zero, only matches started after it are returned.
+// If queue isn't zero, only matches of this queue id are returned.
+// If typ isn't empty, only matches of this type are returned.
+func (api *RiotAPI) MatchIDs(ctx context.Context, region, puuid string, since time.Time, queue int, typ string, count int) ([]string, error) {
+	q := url.Values{}
+	q.Set("count", strconv.Itoa(count))
+	if queue != 0 {
+		q.Set("queue", strconv.Itoa(queue))
+	}
+	if typ != "" {
+		q.Set("type", typ)
+	}
+	if !since.IsZero() {
+		q.Set("startTime", strconv.FormatInt(since.Unix(), 10))
+	}
+	var ids []string
+	path := fmt.Sprintf("/lol/match/v5/matches/by-puuid/%s/ids?%s", url.PathEscape(puuid), q.Encode())
+	if err := api.get(ctx, regionalHost(region), path, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+type Match struct {
+	Info struct {
+		// Timestamps in milliseconds since the epoch.
+		GameCreation     int64 `json:"gameCreation"`
+		GameEndTimestamp int64 `json:"gameEndTimestamp"`
+		// GameDuration is in milliseconds in matches without GameEndTimestamp,
+		// in seconds otherwise.
+		GameDuration int64 `json:"gameDuration"`
+		QueueID      int   `json:"queueId"`
+	} `json:"info"`
+}
+
+// Duration returns the length of the game.
+func (m *Match) Duration() time.Duration {
+	switch {
+	case m.Info.GameDuration == 0:
+		return m.End().Sub(m.Start())
+	case m.Info.GameEndTimestamp == 0:
+		return time.Duration(m.Info.GameDuration) * time.Millisecond
+	default:
+		return time.Duration(m.Info.GameDuration) * time.Second
+	}
+}
+
+func (m *Match) End() time.Time {
+	ms := m.Info.GameEndTimestamp
+	if ms == 0 {
+		ms = m.Info.GameCreation
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func (m *Match) Start() time.Time {
+	return time.Unix(0, m.Info.GameCreation*int64(time.Millisecond))
+}
+
+func (api *RiotAPI) Match(ctx context.Context, region, id string) (*Match, error) {
+	m := new(Match)
+	path := "/lol/match/v5/matches/" + url.PathEscape(id)
+	if err := api.get(ctx, regionalHost(region), path, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+const (
+	// rateLimitRetries is how often a rate limited request is retried.
+	rateLimitRetries = 3
+	// maxRetryAfter is the longest Retry-After that is waited for,
+	// the request fails with a RateLimitError otherwise.
+	maxRetryAfter = time.Minute
+	// defaultRetryAfter is used if a 429 response has no valid Retry-After.
+	defaultRetryAfter = time.Second
+)
+
+// RateLimitError is returned if the Riot API still limits the requests
+// after retrying or asks to wait longer than maxRetryAfter.
+type RateLimitError struct {
+	URL        string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("riot api: %s is rate limited, retry after %v", e.URL, e.RetryAfter)
+}
+
+func (api *RiotAPI) get(ctx context.Context, host, path string, v interface{}) error {
+	u := "https://" + host + path
+	if api.BaseURL != "" {
+		u = api.BaseURL + path
+	}
+	for retries := 0; ; retries++ {
+		err := api.getOnce(ctx, u, v)
+		rl, ok := err.(*RateLimitError)
+		if !ok || retries == rateLimitRetries || rl.RetryAfter > maxRetryAfter {
+			return err
+		}
+		if !sleep(ctx, rl.RetryAfter) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (api *RiotAPI) getOnce(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Riot-Token", api.Key)
+
+	res, err := api.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed opening URL: %s, %v", u, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case res.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitError{URL: u, RetryAfter: retryAfter(res.Header.Get("Retry-After"), time.Now())}
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("riot api: %s returned %s", u, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("riot api: failed decoding response of %s, %v", u, err)
+	}
+	return nil
+}
+
+// retryAfter parses the Retry-After header h,
+// which is either a number of seconds or an HTTP date.
+func retryAfter(h string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// regionalHost returns the host of the regional routing value
+// that serves the account and match endpoints for a platform.
+func regionalHost(region string) string {
+	switch region {
+	case "br", "lan", "las", "na", "pbe":
+		return "americas.api.riotgames.com"
+	case "kr", "jp":
+		return "asia.api.riotgames.com"
+	case "oce", "sea":
+		return "sea.api.riotgames.com"
+	default:
+		return "europe.api.riotgames.com"
+	}
+}
+
+// accountHost is like regionalHost,
+// but the account endpoints aren't served by the sea cluster.
+func accountHost(region string) string {
+	if h := regionalHost(region); h != "sea.api.riotgames.com" {
+		return h
+	}
+	return "asia.api.riotgames.com"
+}
+
+var platformHosts = map[string]string{
+	"br":   "br1.api.riotgames.com",
+	"eune": "eun1.api.riotgames.com",
+	"euw":  "euw1.api.riotgames.com",
+	"lan":  "la1.api.riotgames.com",
+	"las":  "la2.api.riotgames.com",
+	"na":   "na1.api.riotgames.com",
+	"oce":  "oc1.api.riotgames.com",
+	"ru":   "ru.api.riotgames.com",
+	"tr":   "tr1.api.riotgames.com",
+	"jp":   "jp1.api.riotgames.com",
+	"sea":  "sg2.api.riotgames.com",
+	"kr":   "kr.api.riotgames.com",
+	"pbe":  "pbe1.api.riotgames.com",
+}
@@ -0,0 +1,83 @@
+package elo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRiotRateLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		// limited is the number of 429 responses before a success.
+		limited    int
+		retryAfter string
+		timeout    time.Duration
+		// wantRequests is the number of expected requests.
+		wantRequests int
+		wantLimited  bool
+	}{
+		{name: "retried", limited: 2, retryAfter: "0", wantRequests: 3},
+		{name: "exhausted", limited: 100, retryAfter: "0", wantRequests: rateLimitRetries + 1, wantLimited: true},
+		{name: "too long", limited: 1, retryAfter: "3600", wantRequests: 1, wantLimited: true},
+		{name: "canceled", limited: 1, retryAfter: "30", timeout: 50 * time.Millisecond, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tt.limited {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{"puuid": "p", "summonerLevel": 30}`))
+			}))
+			defer srv.Close()
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			api := &RiotAPI{Client: srv.Client(), BaseURL: srv.URL}
+			s, err := api.SummonerByPUUID(ctx, "euw", "p")
+			if requests != tt.wantRequests {
+				t.Fatalf("expected %d requests, got %d", tt.wantRequests, requests)
+			}
+			_, limited := err.(*RateLimitError)
+			switch {
+			case tt.wantLimited && !limited:
+				t.Fatalf("expected a RateLimitError, got %v", err)
+			case tt.timeout > 0 && err != context.DeadlineExceeded:
+				t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+			case tt.wantRequests > tt.limited && (err != nil || s.SummonerLevel != 30):
+				t.Fatalf("expected the summoner, got %+v (err: %v)", s, err)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"Wed, 15 May 2019 15:55:30 GMT", 30 * time.Second},
+		{"Wed, 15 May 2019 15:00:00 GMT", 0},
+		{"", defaultRetryAfter},
+		{"-1", defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, expected %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package elo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/notify"
+)
+
+type Status int
+
+const (
+	StatusQueued Status = iota
+	StatusRunning
+	StatusUpdated
+	StatusNotFound
+	StatusError
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusQueued:
+		return "queued"
+	case StatusRunning:
+		return "running"
+	case StatusUpdated:
+		return "updated"
+	case StatusNotFound:
+		return "not found"
+	case StatusError:
+		return "error"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+type Result struct {
+	Status Status
+	Err    error
+	Time   time.Time
+}
+
+func (r Result) InFlight() bool {
+	return r.Status == StatusQueued || r.Status == StatusRunning
+}
+
+// Finished results are only reported for this long.
+const resultTTL = 10 * time.Minute
+
+// lookupPause is the wait between the lookups of two accounts.
+var lookupPause = time.Second
+
+// Updater keeps the elo of the accounts in DB up to date.
+//
+// Besides updating all accounts with UpdateAll,
+// single accounts can be queued for an immediate lookup.
+// Queued accounts are processed one after another by Run,
+// an account that is already queued or running isn't queued again.
+type Updater struct {
+	DB *db.DB
+	// LeagueOfGraphs is used to look up profiles,
+	// the default client is used if it is nil.
+	LeagueOfGraphs *LeagueOfGraphs
+	// Riot is used to track accounts by their PUUID, it may be nil.
+	Riot *RiotAPI
+	// Events records changes of accounts found by lookups.
+	Events *notify.Dispatcher
+	// Logger is used for failed updates by UpdateAll
+	// and failed optional parts of a lookup, defaults to stderr.
+	Logger *log.Logger
+
+	once    sync.Once
+	mu      sync.Mutex
+	wake    chan struct{}
+	pending []int
+	results map[int]Result
+}
+
+func (u *Updater) init() {
+	u.once.Do(func() {
+		u.wake = make(chan struct{}, 1)
+		u.results = make(map[int]Result)
+		if u.Logger == nil {
+			u.Logger = log.New(os.Stderr, "ERROR ", log.LstdFlags)
+		}
+	})
+}
+
+// Queue schedules a lookup for the account with the given id.
+// It returns false if a lookup for this account is already in flight.
+func (u *Updater) Queue(id int) bool {
+	u.init()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if res, ok := u.results[id]; ok && res.InFlight() {
+		return false
+	}
+	u.results[id] = Result{Status: StatusQueued, Time: time.Now()}
+	u.pending = append(u.pending, id)
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// QueueAll queues every account that isn't excluded from updates.
+func (u *Updater) QueueAll() error {
+	accs, err := u.DB.Accounts()
+	if err != nil {
+		return fmt.Errorf("failed reading accounts from database, %v", err)
+	}
+	for _, acc := range accs {
+		if acc.TrackPriority() != db.PriorityOff {
+			u.Queue(acc.ID)
+		}
+	}
+	return nil
+}
+
+// Result returns the state of the last lookup for the account with the given id.
+// ok is false if there is none or it finished too long ago.
+func (u *Updater) Result(id int) (res Result, ok bool) {
+	u.init()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	res, ok = u.results[id]
+	if ok && !res.InFlight() && time.Since(res.Time) > resultTTL {
+		delete(u.results, id)
+		return Result{}, false
+	}
+	return res, ok
+}
+
+// Run processes queued accounts until ctx is done.
+func (u *Updater) Run(ctx context.Context) {
+	u.init()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-u.wake:
+		}
+		for {
+			id, ok := u.next()
+			if !ok {
+				break
+			}
+			u.set(id, Result{Status: StatusRunning})
+			u.set(id, u.refresh(ctx, id))
+			if !sleep(ctx, lookupPause) {
+				return
+			}
+		}
+	}
+}
+
+// sleep pauses for d and reports whether ctx is still active.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (u *Updater) next() (int, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.pending) == 0 {
+		return 0, false
+	}
+	id := u.pending[0]
+	u.pending = u.pending[1:]
+	return id, true
+}
+
+func (u *Updater) set(id int, res Result) {
+	res.Time = time.Now()
+	u.mu.Lock()
+	u.results[id] = res
+	u.mu.Unlock()
+}
+
+func (u *Updater) refresh(ctx context.Context, id int) Result {
+	acc, err := u.DB.Account(id)
+	if err != nil {
+		return Result{Status: StatusError, Err: fmt.Errorf("couldn't get account with id %d from database, %v", id, err)}
+	}
+	// Queued lookups are requested by a user, who expects a current rank.
+	switch err := u.update(ctx, acc, true); err {
+	case nil:
+		return Result{Status: StatusUpdated}
+	case ErrNotFound:
+		return Result{Status: StatusNotFound}
+	default:
+		return Result{Status: StatusError, Err: err}
+	}
+}
+
+// Intervals between automatic updates by priority.
+var intervals = map[db.Priority]time.Duration{
+	db.PriorityLow:    7 * 24 * time.Hour,
+	db.PriorityNormal: 24 * time.Hour,
+	db.PriorityHigh:   6 * time.Hour,
+}
+
+// due reports whether acc should be updated by UpdateAll.
+func due(acc *db.Account, now time.Time) bool {
+	interval, ok := intervals[acc.TrackPriority()]
+	if !ok {
+		return false
+	}
+	// Some slack, so that an account isn't skipped
+	// because the last update of it took a bit longer.
+	return !acc.EloChecked.Valid || now.Sub(acc.EloChecked.Time) >= interval-time.Hour
+}
+
+// UpdateAll updates every account that is due according to its priority.
+// A failed update is logged and doesn't stop the others,
+// the returned error summarizes the failures.
+func (u *Updater) UpdateAll(ctx context.Context) error {
+	u.init()
+	accs, err := u.DB.Accounts()
+	if err != nil {
+		return fmt.Errorf("failed reading accounts from database, %v", err)
+	}
+	updates, failed := 0, make([]string, 0)
+	for _, acc := range accs {
+		if !due(acc, time.Now()) {
+			continue
+		}
+		updates++
+		if !sleep(ctx, lookupPause) {
+			return ctx.Err()
+		}
+		err := u.update(ctx, acc, false)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && err != ErrNotFound {
+			u.Logger.Printf("elo: update of account %d failed, %v", acc.ID, err)
+			failed = append(failed, strconv.Itoa(acc.ID))
+		}
+		// The following lookups would be limited as well.
+		if rl, ok := err.(*RateLimitError); ok && !sleep(ctx, rl.RetryAfter) {
+			return ctx.Err()
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d updates failed (Account-IDs: %s)", len(failed), updates, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// update looks up acc and stores the changes,
+// force revalidates cached responses of LeagueOfGraphs.
+func (u *Updater) update(ctx context.Context, acc *db.Account, force bool) error {
+	now := time.Now()
+	p, err := u.lookup(ctx, acc, now, force)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		if err := u.DB.EditEloChecked(acc.ID, now); err != nil {
+			return fmt.Errorf("couldn't update elo lookup time in database (Account-ID: %d), %v", acc.ID, err)
+		}
+		if err == ErrNotFound && (acc.EloUpdated.Valid || acc.PUUID != "") {
+			reason := "Not found anymore, the account might be renamed, transferred or banned"
+			if err := u.flag(acc, reason, now); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	if err := u.DB.EditElo(acc.ID, p.Ranks, now); err != nil {
+		return fmt.Errorf("couldn't update elo in database (Account-ID: %d), %v", acc.ID, err)
+	}
+	if err := u.rankChanges(acc, p, now); err != nil {
+		return err
+	}
+	if a := p.Activity; a != nil {
+		lastPlayed := db.NullTime{Time: a.LastPlayed, Valid: !a.LastPlayed.IsZero()}
+		if err := u.DB.EditActivity(acc.ID, lastPlayed, a.RecentGames); err != nil {
+			return fmt.Errorf("couldn't update activity in database (Account-ID: %d), %v", acc.ID, err)
+		}
+	}
+	if err := u.leaverbuster(ctx, acc, now); err != nil {
+		return err
+	}
+	if p.Level > 0 && p.Level != acc.Level {
+		return u.updateLevel(acc, p.Level, now)
+	}
+	return nil
+}
+
+const (
+	// leaverbusterMatches is the number of matches per type
+	// looked up for the leaverbuster games.
+	leaverbusterMatches = 20
+	// Shorter games are remakes, which don't count as leaverbuster games.
+	minGameDuration = 5 * time.Minute
+)
+
+// leaverbuster counts the games played since the leaverbuster games
+// of acc were last counted. Only matchmade games count,
+// without ARAM and remakes.
+func (u *Updater) leaverbuster(ctx context.Context, acc *db.Account, now time.Time) error {
+	if u.Riot == nil || acc.PUUID == "" || acc.LeaverbusterGames == 0 || !acc.LeaverbusterSince.Valid {
+		return nil
+	}
+	ids := make([]string, 0)
+	for _, typ := range []string{MatchRanked, MatchNormal} {
+		typeIDs, err := u.Riot.MatchIDs(ctx, acc.Region, acc.PUUID, acc.LeaverbusterSince.Time, 0, typ, leaverbusterMatches)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, typeIDs...)
+	}
+	games := 0
+	var newest time.Time
+	for _, id := range ids {
+		m, err := u.Riot.Match(ctx, acc.Region, id)
+		if err != nil {
+			return err
+		}
+		if m.Info.QueueID == QueueARAM || m.Duration() < minGameDuration {
+			continue
+		}
+		games++
+		if m.Start().After(newest) {
+			newest = m.Start()
+		}
+	}
+	if games == 0 {
+		return nil
+	}
+
+	// A game running right now started after the newest one.
+	left, err := u.DB.PlayLeaverbuster(acc.ID, games, newest.Add(time.Second))
+	if err == db.ErrNoLeaverbuster {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't count leaverbuster games in database (Account-ID: %d), %v", acc.ID, err)
+	}
+	acc.LeaverbusterGames = left
+
+	msg := fmt.Sprintf("Detected %d leaverbuster games, %d left", games, left)
+	if left == 0 {
+		msg = fmt.Sprintf("Detected %d leaverbuster games, leaverbuster done", games)
+	}
+	e := &db.Event{
+		AccountID: acc.ID,
+		Time:      now,
+		Kind:      db.EventLeaverbuster,
+		Message:   msg,
+	}
+	if err := u.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("couldn't add leaverbuster games to history (Account-ID: %d), %v", acc.ID, err)
+	}
+	return nil
+}
+
+func (u *Updater) lookup(ctx context.Context, acc *db.Account, now time.Time, force bool) (*Profile, error) {
+	if err := u.resolve(ctx, acc, now); err != nil {
+		return nil, err
+	}
+	lg := u.LeagueOfGraphs
+	if lg == nil {
+		lg = defaultLeagueOfGraphs
+	}
+	p, err := lg.get(ctx, acc.Region, acc.IGN, acc.TagLine, force)
+	if err != nil {
+		return nil, err
+	}
+	if u.Riot == nil || acc.PUUID == "" {
+		return p, nil
+	}
+
+	s, err := u.Riot.SummonerByPUUID(ctx, acc.Region, acc.PUUID)
+	switch err {
+	case nil:
+		p.Level = s.SummonerLevel
+	case ErrNotFound:
+		reason := fmt.Sprintf("No summoner on %s, the account might be transferred or banned", acc.Region)
+		return p, u.flag(acc, reason, now)
+	default:
+		return nil, err
+	}
+
+	// The TFT rank is optional, API keys without access to TFT are rejected.
+	// The stored rank is kept if the lookup fails.
+	tft, err := u.Riot.TFTRank(ctx, acc.Region, acc.PUUID)
+	if err != nil {
+		u.Logger.Printf("elo: TFT rank of account %d, %v", acc.ID, err)
+	} else {
+		p.Ranks[db.QueueTFT] = tft
+	}
+
+	// Without the activity, the last played time and recent games are kept.
+	if p.Activity, err = u.Riot.Activity(ctx, acc.Region, acc.PUUID, now); err != nil {
+		u.Logger.Printf("elo: activity of account %d, %v", acc.ID, err)
+		p.Activity = nil
+	}
+	return p, nil
+}
+
+// rankChanges emits an event for every queue
+// in which p has a different rank than acc.
+func (u *Updater) rankChanges(acc *db.Account, p *Profile, now time.Time) error {
+	lastPlayed := acc.LastPlayed
+	if a := p.Activity; a != nil {
+		lastPlayed = db.NullTime{Time: a.LastPlayed, Valid: !a.LastPlayed.IsZero()}
+	}
+
+	for _, q := range db.Queues {
+		rank, ok := p.Ranks[q]
+		if !ok {
+			continue
+		}
+		old := acc.Rank(q)
+		cmp, ok := compareRanks(old, rank)
+		if !ok || cmp == 0 {
+			continue
+		}
+
+		kind := db.EventPromotion
+		if cmp < 0 {
+			kind = db.EventDemotion
+			if d, ok := DecayIn(old, lastPlayed.Time, now); ok && lastPlayed.Valid && d <= 0 && q != db.QueueTFT {
+				kind = db.EventDecay
+			}
+		}
+		e := &db.Event{
+			AccountID: acc.ID,
+			Time:      now,
+			Kind:      kind,
+			Message:   fmt.Sprintf("%s: %s → %s", q.Name(), old, rank),
+		}
+		if err := u.Events.Emit(e, acc); err != nil {
+			return fmt.Errorf("couldn't add rank change to history (Account-ID: %d), %v", acc.ID, err)
+		}
+	}
+	return nil
+}
+
+// flag marks acc for review by a human,
+// the ban fields are never changed automatically.
+func (u *Updater) flag(acc *db.Account, reason string, now time.Time) error {
+	if acc.Review != "" {
+		return nil
+	}
+	acc.Review = reason
+	if err := u.DB.EditReview(acc.ID, reason); err != nil {
+		return fmt.Errorf("couldn't flag account for review in database (Account-ID: %d), %v", acc.ID, err)
+	}
+	e := &db.Event{
+		AccountID: acc.ID,
+		Time:      now,
+		Kind:      db.EventReview,
+		Message:   "Flagged for review: " + reason,
+	}
+	if err := u.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("couldn't add review flag to history (Account-ID: %d), %v", acc.ID, err)
+	}
+	return nil
+}
+
+// updateLevel stores the summoner level and sets Pre30 accordingly.
+func (u *Updater) updateLevel(acc *db.Account, level int, now time.Time) error {
+	pre30 := level < 30
+	if err := u.DB.EditLevel(acc.ID, level); err != nil {
+		return fmt.Errorf("couldn't update level in database (Account-ID: %d), %v", acc.ID, err)
+	}
+	if pre30 == acc.Pre30 {
+		acc.Level = level
+		return nil
+	}
+	acc.Level, acc.Pre30 = level, pre30
+
+	msg := fmt.Sprintf("Reached level %d, Pre 30 removed", level)
+	if pre30 {
+		msg = fmt.Sprintf("Level %d, marked as Pre 30", level)
+	}
+	e := &db.Event{
+		AccountID: acc.ID,
+		Time:      now,
+		Kind:      db.EventLevel,
+		Message:   msg,
+	}
+	if err := u.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("couldn't add level change to history (Account-ID: %d), %v", acc.ID, err)
+	}
+	return nil
+}
+
+// resolve stores the PUUID of acc on the first successful lookup.
+// Afterwards the PUUID is used to follow changes of the Riot ID.
+func (u *Updater) resolve(ctx context.Context, acc *db.Account, now time.Time) error {
+	if u.Riot == nil {
+		return nil
+	}
+
+	tagLine := acc.TagLine
+	if tagLine == "" {
+		tagLine = DefaultTagLine(acc.Region)
+	}
+
+	var ra *RiotAccount
+	var err error
+	if acc.PUUID == "" {
+		ra, err = u.Riot.AccountByRiotID(ctx, acc.Region, acc.IGN, tagLine)
+	} else {
+		ra, err = u.Riot.AccountByPUUID(ctx, acc.Region, acc.PUUID)
+	}
+	if err != nil {
+		return err
+	}
+	if ra.PUUID == acc.PUUID && ra.GameName == acc.IGN && ra.TagLine == acc.TagLine {
+		return nil
+	}
+
+	renamed := !strings.EqualFold(ra.GameName, acc.IGN) || !strings.EqualFold(ra.TagLine, tagLine)
+	old := acc.RiotID()
+	acc.IGN, acc.TagLine, acc.PUUID = ra.GameName, ra.TagLine, ra.PUUID
+	if err := u.DB.EditRiotID(acc.ID, acc.IGN, acc.TagLine, acc.PUUID); err != nil {
+		return fmt.Errorf("couldn't update riot id in database (Account-ID: %d), %v", acc.ID, err)
+	}
+	if !renamed {
+		return nil
+	}
+
+	e := &db.Event{
+		AccountID: acc.ID,
+		Time:      now,
+		Kind:      db.EventRename,
+		Message:   fmt.Sprintf("Renamed from %s to %s", old, acc.RiotID()),
+	}
+	if err := u.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("couldn't add rename to history (Account-ID: %d), %v", acc.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package elo
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/notify"
+)
+
+// testUpdater returns an Updater which looks up profiles with hf.
+// stop has to be called after the test.
+func testUpdater(t *testing.T, hf http.HandlerFunc) (u *Updater, stop func()) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Init(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(hf)
+	pause := lookupPause
+	lookupPause = 0
+
+	u = &Updater{
+		DB:             d,
+		LeagueOfGraphs: &LeagueOfGraphs{Client: srv.Client(), BaseURL: srv.URL},
+		Events:         &notify.Dispatcher{DB: d},
+		Logger:         log.New(ioutil.Discard, "", 0),
+	}
+	return u, func() {
+		lookupPause = pause
+		srv.Close()
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// addAccounts adds an account for every Riot ID name#EUW.
+func addAccounts(t *testing.T, d *db.DB, names ...string) []*db.Account {
+	accs := make([]*db.Account, 0)
+	for _, name := range names {
+		acc := &db.Account{Region: "euw", IGN: name, TagLine: "EUW"}
+		if err := d.AddAccount(acc); err != nil {
+			t.Fatal(err)
+		}
+		accs = append(accs, acc)
+	}
+	return accs
+}
+
+func TestUpdateAll(t *testing.T) {
+	ranked, err := ioutil.ReadFile(filepath.Join("testdata", "ranked.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, stop := testUpdater(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/en/summoner/euw/broken-EUW":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "/en/summoner/euw/ranked-EUW":
+			w.Write(ranked)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+	accs := addAccounts(t, u.DB, "broken", "missing", "ranked")
+
+	err = u.UpdateAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 updates failed") {
+		t.Fatalf("expected only the failure of broken, got %v", err)
+	}
+	acc, err := u.DB.Account(accs[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Rank(db.QueueSolo) != "Gold II" {
+		t.Fatalf("account after the failure not updated, got %+v", acc)
+	}
+}
+
+func TestDue(t *testing.T) {
+	now := time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)
+	checked := func(d time.Duration) db.NullTime {
+		return db.NullTime{Time: now.Add(-d), Valid: true}
+	}
+	tests := []struct {
+		acc  db.Account
+		want bool
+	}{
+		{db.Account{}, true},
+		{db.Account{Perma: true}, false},
+		{db.Account{Track: db.PriorityOff}, false},
+		{db.Account{Track: db.PriorityHigh, Perma: true}, true},
+		{db.Account{EloChecked: checked(2 * time.Hour)}, false},
+		{db.Account{EloChecked: checked(24 * time.Hour)}, true},
+		{db.Account{Track: db.PriorityHigh, EloChecked: checked(6 * time.Hour)}, true},
+		{db.Account{Track: db.PriorityLow, EloChecked: checked(24 * time.Hour)}, false},
+		{db.Account{Track: db.PriorityLow, EloChecked: checked(7 * 24 * time.Hour)}, true},
+	}
+	for i, tt := range tests {
+		if got := due(&tt.acc, now); got != tt.want {
+			t.Errorf("%d: expected %t, got %t (%+v)", i, tt.want, got, tt.acc)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ranked, err := ioutil.ReadFile(filepath.Join("testdata", "ranked.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	lookups := make([]string, 0)
+	u, stop := testUpdater(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lookups = append(lookups, strings.TrimSuffix(path.Base(r.URL.Path), "-EUW"))
+		mu.Unlock()
+		w.Write(ranked)
+	})
+	defer stop()
+	accs := addAccounts(t, u.DB, "first", "second", "third", "off")
+	off := accs[3]
+	off.Track = db.PriorityOff
+	if err := u.DB.EditAccount(off.ID, off); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is processed before Run, so every lookup is still pending.
+	if !u.Queue(accs[2].ID) || !u.Queue(accs[0].ID) {
+		t.Fatal("expected the accounts to be queued")
+	}
+	if u.Queue(accs[2].ID) {
+		t.Fatal("queued an account that is already in flight")
+	}
+	if err := u.QueueAll(); err != nil {
+		t.Fatal(err)
+	}
+	if res, ok := u.Result(accs[1].ID); !ok || res.Status != StatusQueued {
+		t.Fatalf("expected the account to be queued by QueueAll, got %+v", res)
+	}
+	if _, ok := u.Result(off.ID); ok {
+		t.Fatal("QueueAll queued an account without tracking")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for _, acc := range accs[:3] {
+		for {
+			res, ok := u.Result(acc.ID)
+			if ok && !res.InFlight() {
+				if res.Status != StatusUpdated {
+					t.Fatalf("%s: expected status %v, got %v (%v)", acc.IGN, StatusUpdated, res.Status, res.Err)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: lookup didn't finish, got %+v", acc.IGN, res)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	cancel()
+
+	mu.Lock()
+	got := strings.Join(lookups, " ")
+	mu.Unlock()
+	if want := "third first second"; got != want {
+		t.Fatalf("expected the lookups %q in queue order and once each, got %q", want, got)
+	}
+
+	// Finished results are forgotten after resultTTL.
+	u.mu.Lock()
+	res := u.results[accs[0].ID]
+	res.Time = time.Now().Add(-resultTTL - time.Second)
+	u.results[accs[0].ID] = res
+	u.mu.Unlock()
+	if _, ok := u.Result(accs[0].ID); ok {
+		t.Fatal("expected the result to expire")
+	}
+	if _, ok := u.Result(accs[1].ID); !ok {
+		t.Fatal("recent result expired")
+	}
+}
+
+func TestLookupPartial(t *testing.T) {
+	ranked, err := ioutil.ReadFile(filepath.Join("testdata", "ranked.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// Requests of paths with this prefix fail with status.
+		prefix string
+		status int
+	}{
+		{"tft forbidden", "/tft/league/v1/", http.StatusForbidden},
+		{"match unavailable", "/lol/match/v5/", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, stop := testUpdater(t, func(w http.ResponseWriter, r *http.Request) {
+				switch p := r.URL.Path; {
+				case strings.HasPrefix(p, tt.prefix):
+					http.Error(w, http.StatusText(tt.status), tt.status)
+				case p == "/en/summoner/euw/ranked-EUW":
+					w.Write(ranked)
+				case strings.HasPrefix(p, "/riot/account/v1/"):
+					w.Write([]byte(`{"puuid": "puuid", "gameName": "ranked", "tagLine": "EUW"}`))
+				case strings.HasPrefix(p, "/lol/summoner/v4/"):
+					w.Write([]byte(`{"puuid": "puuid", "summonerLevel": 187}`))
+				case strings.HasPrefix(p, "/tft/league/v1/"):
+					w.Write([]byte(`[{"queueType": "RANKED_TFT", "tier": "DIAMOND", "rank": "I"}]`))
+				case strings.HasPrefix(p, "/lol/match/v5/"):
+					w.Write([]byte(`[]`))
+				default:
+					http.NotFound(w, r)
+				}
+			})
+			defer stop()
+			u.Riot = &RiotAPI{Client: http.DefaultClient, BaseURL: u.LeagueOfGraphs.BaseURL}
+
+			id := addAccounts(t, u.DB, "ranked")[0].ID
+			if err := u.DB.EditRiotID(id, "ranked", "EUW", "puuid"); err != nil {
+				t.Fatal(err)
+			}
+			old := map[db.Queue]string{db.QueueSolo: "Silver I", db.QueueFlex: "Silver I", db.QueueTFT: "Gold I"}
+			if err := u.DB.EditElo(id, old, time.Now().Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			lastPlayed := db.NullTime{Time: time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC), Valid: true}
+			if err := u.DB.EditActivity(id, lastPlayed, 3); err != nil {
+				t.Fatal(err)
+			}
+			acc, err := u.DB.Account(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := u.update(context.Background(), acc, true); err != nil {
+				t.Fatal(err)
+			}
+			if acc, err = u.DB.Account(id); err != nil {
+				t.Fatal(err)
+			}
+			if acc.Rank(db.QueueSolo) != "Gold II" || acc.Rank(db.QueueFlex) != "Platinum IV" || acc.Level != 187 {
+				t.Fatalf("ranks of leagueofgraphs not stored, got %+v", acc)
+			}
+			if tt.prefix == "/tft/league/v1/" && acc.Rank(db.QueueTFT) != "Gold I" {
+				t.Fatalf("expected the old TFT rank, got %q", acc.Rank(db.QueueTFT))
+			}
+			if tt.prefix == "/lol/match/v5/" && (!acc.LastPlayed.Time.Equal(lastPlayed.Time) || acc.RecentGames != 3) {
+				t.Fatalf("expected the old activity, got %v and %d games", acc.LastPlayed.Time, acc.RecentGames)
+			}
+		})
+	}
+}
+
+func TestLeaverbuster(t *testing.T) {
+	u, stop := testUpdater(t, http.NotFound)
+	defer stop()
+	srv := matchServer(t)
+	defer srv.Close()
+	u.Riot = &RiotAPI{Client: srv.Client(), BaseURL: srv.URL}
+
+	// Since then, a normal game, a remake, a custom game and an ARAM game were played.
+	since := time.Date(2019, 5, 10, 0, 0, 0, 0, time.UTC)
+	acc := &db.Account{
+		Region:            "euw",
+		IGN:               "player",
+		PUUID:             "puuid",
+		Leaverbuster:      10,
+		LeaverbusterGames: 3,
+		LeaverbusterSince: db.NullTime{Time: since, Valid: true},
+	}
+	if err := u.DB.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.leaverbuster(context.Background(), acc, time.Date(2019, 5, 15, 15, 55, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := u.DB.Account(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2019, 5, 13, 12, 0, 1, 0, time.UTC)
+	if got.LeaverbusterGames != 2 || got.Leaverbuster != 10 || !got.LeaverbusterSince.Time.Equal(want) {
+		t.Fatalf("expected only the normal game to count, got %d games left since %v", got.LeaverbusterGames, got.LeaverbusterSince.Time)
+	}
+}
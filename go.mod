@@ -6,4 +6,5 @@ require (
 	github.com/erikfastermann/httpwrap v0.0.0-20191211133712-5c60873903eb
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
+	rsc.io/qr v0.2.0
 )
@@ -3,14 +3,19 @@ package handler
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/erikfastermann/lam/db"
 )
 
 func (h *Handler) add(username string, w http.ResponseWriter, r *http.Request) error {
 	if r.Method == http.MethodGet {
+		users, err := h.usernames()
+		if err != nil {
+			return err
+		}
 		acc := db.Account{Region: "euw", User: username}
-		data := editPage{Title: "Add new account", Users: h.usernames(), Username: username, Account: acc}
+		data := editPage{page: newPage(username, r), Title: "Add new account", Users: users, Account: acc}
 		return h.Templates.ExecuteTemplate(w, templateEdit, data)
 	}
 
@@ -18,10 +23,22 @@ func (h *Handler) add(username string, w http.ResponseWriter, r *http.Request) e
 	if err != nil {
 		return badRequestf("failed validating form input, %v", err)
 	}
+	now := time.Now()
+	startLeaverbuster(&db.Account{}, acc, now)
 
 	if err := h.DB.AddAccount(acc); err != nil {
 		return fmt.Errorf("writing to database failed, %v", err)
 	}
+	if err := h.recordPenalties(&db.Account{}, acc, now); err != nil {
+		return fmt.Errorf("adding penalties of account with id %d failed, %v", acc.ID, err)
+	}
+	e := &db.Event{AccountID: acc.ID, Time: now, Kind: db.EventAdd, Message: "Added by " + username}
+	if err := h.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("adding account with id %d to history failed, %v", acc.ID, err)
+	}
+	if acc.TrackPriority() != db.PriorityOff {
+		h.Updater.Queue(acc.ID)
+	}
 
 	http.Redirect(w, r, routeOverview, http.StatusSeeOther)
 	return nil
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+// Only the newest entries of the audit log are shown.
+const auditLength = 200
+
+func (h *Handler) audit(username string, w http.ResponseWriter, r *http.Request) error {
+	type auditPage struct {
+		page
+		Entries []*db.AuditEntry
+	}
+
+	entries, err := h.DB.AuditLog()
+	if err != nil {
+		return fmt.Errorf("couldn't read audit log from database, %v", err)
+	}
+	if len(entries) > auditLength {
+		entries = entries[:auditLength]
+	}
+
+	data := auditPage{page: newPage(username, r), Entries: entries}
+	return h.Templates.ExecuteTemplate(w, templateAudit, data)
+}
@@ -2,16 +2,36 @@ package handler
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/erikfastermann/httpwrap"
+	"github.com/erikfastermann/lam/db"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const sessToken = "session_token"
 
+// dummyHash is compared for unknown usernames,
+// it has the default cost of bcrypt.
+const dummyHash = "$2a$10$1UPxJZwv0/HOeo/Z/qEg.uQS5MyacZBfvECCaN8AFjWgPFRf5vXb."
+
+// errLoginFailed is returned for every failed sign in,
+// it doesn't reveal whether the username exists or the sign in was throttled.
+var errLoginFailed = httpwrap.Error{
+	StatusCode: http.StatusUnauthorized,
+	Err:        errors.New("invalid username or password"),
+}
+
 func unauthf(format string, a ...interface{}) error {
 	return httpwrap.Error{
 		StatusCode: http.StatusUnauthorized,
@@ -19,63 +39,314 @@ func unauthf(format string, a ...interface{}) error {
 	}
 }
 
-func (h *Handler) findUsername(username string) (*User, bool) {
-	for _, u := range h.Users {
-		if u.Username != username {
-			continue
-		}
-		return u, true
+// activeUser returns the user with username,
+// nil if it doesn't exist or is disabled.
+func (h *Handler) activeUser(username string) (*db.User, error) {
+	u, err := h.DB.User(username)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read user %s, %v", username, err)
+	}
+	if u.Disabled {
+		return nil, nil
 	}
-	return nil, false
+	return u, nil
 }
 
-func (h *Handler) findUser(token string) (*User, bool) {
-	for _, u := range h.Users {
-		if u.Token != token {
-			continue
+// SessionStore keeps the sessions of signed in users.
+// Tokens are only passed in hashed form, see hashToken.
+type SessionStore interface {
+	AddSession(s *db.Session) error
+	// Session returns sql.ErrNoRows if there is no session with tokenHash.
+	Session(tokenHash string) (*db.Session, error)
+	// Sessions returns the sessions of username, the most recently active first.
+	Sessions(username string) ([]*db.Session, error)
+	TouchSession(tokenHash string, t time.Time) error
+	// ConfirmSession sets the time the password was last confirmed.
+	ConfirmSession(tokenHash string, t time.Time) error
+	// VerifySession marks a pending session as signed in.
+	VerifySession(tokenHash string, t time.Time) error
+	RemoveSession(tokenHash string) error
+	RemoveSessions(username string) error
+}
+
+const (
+	// The last activity of a session is only written this often,
+	// the cookie is renewed at the same time.
+	touchInterval = time.Minute
+	// sessionLifetime is how long a session lasts at most.
+	sessionLifetime = 30 * 24 * time.Hour
+	// sessionIdle is how long a session lasts without activity.
+	sessionIdle = 7 * 24 * time.Hour
+	// sudoLifetime is how long sensitive actions are allowed
+	// after the password was entered.
+	sudoLifetime = 10 * time.Minute
+	// pendingLifetime is how long the second factor can be entered.
+	pendingLifetime = 10 * time.Minute
+)
+
+// expired reports whether s ended before now.
+func expired(s *db.Session, now time.Time) bool {
+	if s.Pending && now.Sub(s.Created) >= pendingLifetime {
+		return true
+	}
+	return now.Sub(s.Created) >= sessionLifetime || now.Sub(s.LastSeen) >= sessionIdle
+}
+
+// hasRole reports whether role includes the permissions of least.
+// Unknown roles have no permissions.
+func hasRole(role, least string) bool {
+	have, want := -1, -1
+	for i, r := range db.Roles {
+		if r == role {
+			have = i
+		}
+		if r == least {
+			want = i
 		}
-		return u, true
 	}
-	return nil, false
+	return want >= 0 && have >= want
 }
 
-func (h *Handler) signIn(username, password string) (token string, err error) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// sudo reports whether s may perform sensitive actions at now.
+func sudo(s *db.Session, now time.Time) bool {
+	return now.Sub(s.Confirmed) < sudoLifetime
+}
 
-	u, ok := h.findUsername(username)
-	if !ok {
-		return "", unauthf("username %q doesn't exist", username)
+// cookieMaxAge returns the time until s expires
+// if it is active at now.
+func cookieMaxAge(s *db.Session, now time.Time) time.Duration {
+	maxAge := s.Created.Add(sessionLifetime).Sub(now)
+	if maxAge > sessionIdle {
+		maxAge = sessionIdle
+	}
+	return maxAge
+}
+
+// sessionCookie returns the cookie of token,
+// a maxAge below zero deletes it.
+func sessionCookie(r *http.Request, token string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     sessToken,
+		Value:    token,
+		Path:     "/",
+		Secure:   r.URL.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if maxAge < 0 {
+		c.MaxAge = -1
+	} else {
+		c.MaxAge = int(maxAge / time.Second)
+	}
+	return c
+}
+
+// csrfToken returns the token the forms of the session
+// with the cookie token have to include.
+// It can't be derived from the stored hash of token.
+func csrfToken(token string) string {
+	sum := sha256.Sum256([]byte("csrf:" + token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// validCSRF reports whether the form of r includes the CSRF token of its session.
+func validCSRF(r *http.Request) bool {
+	want, _ := r.Context().Value(csrfKey{}).(string)
+	got := r.PostFormValue("csrf_token")
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+type (
+	sessionKey struct{}
+	csrfKey    struct{}
+	roleKey    struct{}
+)
+
+// currentSession returns the session of r, nil if r isn't signed in.
+func currentSession(r *http.Request) *db.Session {
+	s, _ := r.Context().Value(sessionKey{}).(*db.Session)
+	return s
+}
+
+// currentRole returns the role of the user signed in with r.
+func currentRole(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey{}).(string)
+	return role
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signIn starts a new session and removes the expired sessions of the user.
+// Failures are throttled per IP and username and return errLoginFailed.
+// The session is pending if the user has to enter a second factor.
+func (h *Handler) signIn(username, password string, r *http.Request) (token string, s *db.Session, err error) {
+	ip := remoteIP(r)
+	now := time.Now()
+	if !h.userThrottle.allow(username, now) || !h.ipThrottle.allow(ip, now) {
+		return "", nil, errLoginFailed
+	}
+
+	u, err := h.activeUser(username)
+	if err != nil {
+		return "", nil, err
+	}
+	ok := u != nil
+	hash := []byte(dummyHash)
+	if ok {
+		hash = []byte(u.Password)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
-		return "", unauthf("username: %q, %v", username, err)
+	// The hash is compared for unknown usernames as well,
+	// otherwise they could be told apart by the response time.
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		if err := h.loginFailed(username, ip, now); err != nil {
+			return "", nil, err
+		}
+		return "", nil, errLoginFailed
+	}
+	pending, err := h.twoFactorEnabled(u.Username)
+	if err != nil {
+		return "", nil, err
+	}
+	if !pending {
+		// Reset after the second factor otherwise,
+		// it could be guessed without ever getting locked.
+		h.userThrottle.reset(username)
 	}
 
-	b := make([]byte, 64)
+	if err := h.removeExpired(u.Username, now); err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
-		return "", err
+		return "", nil, err
+	}
+	token = base64.URLEncoding.EncodeToString(b)
+
+	s = &db.Session{
+		TokenHash: hashToken(token),
+		Username:  u.Username,
+		Created:   now,
+		LastSeen:  now,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Pending:   pending,
 	}
-	u.Token = base64.URLEncoding.EncodeToString(b)
+	if !pending {
+		s.Confirmed = now
+	}
+	if err := h.Sessions.AddSession(s); err != nil {
+		return "", nil, fmt.Errorf("couldn't store session of %s, %v", u.Username, err)
+	}
+	return token, s, nil
+}
 
-	return u.Token, nil
+// loginFailed records a failed sign in
+// and adds locked IPs and usernames to the audit log.
+func (h *Handler) loginFailed(username, ip string, now time.Time) error {
+	locks := make([]string, 0)
+	if h.userThrottle.fail(username, now) {
+		locks = append(locks, fmt.Sprintf("Sign ins as %q locked for %v after %d failures", username, lockoutDuration, maxUserFailures))
+	}
+	if h.ipThrottle.fail(ip, now) {
+		locks = append(locks, fmt.Sprintf("Sign ins from %s locked for %v after %d failures", ip, lockoutDuration, maxIPFailures))
+	}
+	for _, msg := range locks {
+		e := &db.AuditEntry{Time: now, Kind: db.AuditLockout, Username: username, IP: ip, Message: msg}
+		if err := h.DB.AddAudit(e); err != nil {
+			return fmt.Errorf("couldn't add lockout to audit log, %v", err)
+		}
+	}
+	return nil
 }
 
-func (h *Handler) checkAuth(r *http.Request) (string, error) {
+// reauthenticate reports whether password is the password of the signed in u.
+// Failures are throttled like sign ins,
+// otherwise a stolen session could be used to guess the password.
+func (h *Handler) reauthenticate(u *db.User, password string, r *http.Request) (bool, error) {
+	ip := remoteIP(r)
+	now := time.Now()
+	if !h.userThrottle.allow(u.Username, now) || !h.ipThrottle.allow(ip, now) {
+		return false, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		return false, h.loginFailed(u.Username, ip, now)
+	}
+	h.userThrottle.reset(u.Username)
+	return true, nil
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func (h *Handler) removeExpired(username string, now time.Time) error {
+	sessions, err := h.Sessions.Sessions(username)
+	if err != nil {
+		return fmt.Errorf("couldn't read sessions of %s, %v", username, err)
+	}
+	for _, s := range sessions {
+		if !expired(s, now) {
+			continue
+		}
+		if err := h.Sessions.RemoveSession(s.TokenHash); err != nil {
+			return fmt.Errorf("couldn't remove expired session of %s, %v", username, err)
+		}
+	}
+	return nil
+}
+
+// checkAuth returns the session of r.
+// The session and its cookie are renewed every touchInterval.
+func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request) (*db.Session, *db.User, error) {
 	c, err := r.Cookie(sessToken)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+
+	hash := hashToken(c.Value)
+	s, err := h.Sessions.Session(hash)
+	if err == sql.ErrNoRows {
+		return nil, nil, unauthf("session doesn't exist")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read session, %v", err)
 	}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	now := time.Now()
+	if expired(s, now) {
+		if err := h.Sessions.RemoveSession(hash); err != nil {
+			return nil, nil, fmt.Errorf("couldn't remove expired session of %s, %v", s.Username, err)
+		}
+		return nil, nil, unauthf("session of %s expired", s.Username)
+	}
 
-	token := c.Value
-	u, ok := h.findUser(token)
-	if !ok {
-		return "", unauthf("token %q doesn't exist", token)
+	u, err := h.activeUser(s.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u == nil {
+		return nil, nil, unauthf("user %q of session doesn't exist anymore or is disabled", s.Username)
 	}
 
-	return u.Username, nil
+	if now.Sub(s.LastSeen) > touchInterval {
+		if err := h.Sessions.TouchSession(hash, now); err != nil && err != sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("couldn't update session of %s, %v", s.Username, err)
+		}
+		s.LastSeen = now
+		http.SetCookie(w, sessionCookie(r, c.Value, cookieMaxAge(s, now)))
+	}
+	return s, u, nil
 }
 
 func (h *Handler) login(username string, w http.ResponseWriter, r *http.Request) error {
@@ -85,41 +356,85 @@ func (h *Handler) login(username string, w http.ResponseWriter, r *http.Request)
 	}
 
 	if r.Method == http.MethodGet {
-		if err := h.Templates.ExecuteTemplate(w, templateLogin, nil); err != nil {
+		type loginPage struct {
+			Failed bool
+		}
+		data := loginPage{Failed: r.URL.Query().Get("failed") != ""}
+		if err := h.Templates.ExecuteTemplate(w, templateLogin, data); err != nil {
 			return err
 		}
 		return unauthf("unauthorized")
 	}
 
-	token, err := h.signIn(r.FormValue("username"), r.FormValue("password"))
+	token, s, err := h.signIn(r.FormValue("username"), r.FormValue("password"), r)
 	if err != nil {
-		http.Redirect(w, r, routeLogin, http.StatusSeeOther)
+		http.Redirect(w, r, routeLogin+"?failed=1", http.StatusSeeOther)
 		return err
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessToken,
-		Value:    token,
-		Path:     "/",
-		Secure:   r.URL.Scheme == "https",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	})
+	http.SetCookie(w, sessionCookie(r, token, cookieMaxAge(s, s.Created)))
 
 	http.Redirect(w, r, routeOverview, http.StatusSeeOther)
 	return nil
 }
 
-func (h *Handler) logout(username string, w http.ResponseWriter, r *http.Request) error {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+func (h *Handler) logout(_ string, w http.ResponseWriter, r *http.Request) error {
+	c, err := r.Cookie(sessToken)
+	if err != nil {
+		return err
+	}
+	if err := h.Sessions.RemoveSession(hashToken(c.Value)); err != nil {
+		return fmt.Errorf("logout: couldn't remove session, %v", err)
+	}
+
+	http.SetCookie(w, sessionCookie(r, "", -1))
+	http.Redirect(w, r, routeLogin, http.StatusSeeOther)
+	return nil
+}
+
+func (h *Handler) confirm(username string, w http.ResponseWriter, r *http.Request) error {
+	type confirmPage struct {
+		page
+		Next   string
+		Failed bool
+	}
 
-	u, ok := h.findUsername(username)
+	next := r.FormValue("next")
+	if !localPath(next) {
+		next = routeOverview
+	}
+	if r.Method == http.MethodGet {
+		data := confirmPage{page: newPage(username, r), Next: next}
+		return h.Templates.ExecuteTemplate(w, templateConfirm, data)
+	}
+
+	u, err := h.activeUser(username)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return unauthf("user %q doesn't exist anymore or is disabled", username)
+	}
+	ok, err := h.reauthenticate(u, r.PostForm.Get("password"), r)
+	if err != nil {
+		return err
+	}
 	if !ok {
-		return fmt.Errorf("logout: username %q not found", username)
+		data := confirmPage{page: newPage(username, r), Next: next, Failed: true}
+		if err := h.Templates.ExecuteTemplate(w, templateConfirm, data); err != nil {
+			return err
+		}
+		return unauthf("confirm: wrong password or throttled, username: %q", username)
 	}
-	u.Token = ""
 
-	http.Redirect(w, r, routeLogin, http.StatusSeeOther)
+	if err := h.Sessions.ConfirmSession(currentSession(r).TokenHash, time.Now()); err != nil {
+		return fmt.Errorf("couldn't confirm session of %s, %v", username, err)
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
 	return nil
 }
+
+// localPath reports whether p is a path on this host.
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+// checkout checks out a free account for username
+// or returns it with the action return.
+func (h *Handler) checkout(username string, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Path[1:])
+	if err != nil {
+		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
+	}
+
+	from, to, msg := "", username, "Checked out by "+username
+	if r.PostForm.Get("action") == "return" {
+		from, to, msg = username, "", "Returned by "+username
+	}
+	switch err := h.DB.CheckOut(id, from, to); err {
+	case nil:
+	case sql.ErrNoRows:
+		return badRequestf("couldn't find account with id %d", id)
+	case db.ErrCheckedOut:
+		return badRequestf("account with id %d isn't checked out by %q", id, from)
+	default:
+		return fmt.Errorf("checking out account with id %d failed, %v", id, err)
+	}
+
+	acc, err := h.DB.Account(id)
+	if err != nil {
+		return fmt.Errorf("couldn't get account with id %d from database, %v", id, err)
+	}
+	e := &db.Event{AccountID: id, Time: time.Now(), Kind: db.EventCheckout, Message: msg}
+	if err := h.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("adding checkout to history of account with id %d failed, %v", id, err)
+	}
+
+	redirectBack(w, r)
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
 )
 
 func (h *Handler) edit(username string, w http.ResponseWriter, r *http.Request) error {
@@ -19,23 +22,91 @@ func (h *Handler) edit(username string, w http.ResponseWriter, r *http.Request)
 			return badRequestf("couldn't get account with id %d from database, %v", id, err)
 		}
 
-		title := fmt.Sprintf("Edit: %s", strconv.Quote(acc.IGN))
-		data := editPage{Title: title, Users: h.usernames(), Username: username, Account: *acc}
+		history, err := h.DB.Events(id)
+		if err != nil {
+			return fmt.Errorf("couldn't read history of account with id %d from database, %v", id, err)
+		}
+		penalties, err := h.DB.Penalties(id)
+		if err != nil {
+			return fmt.Errorf("couldn't read penalties of account with id %d from database, %v", id, err)
+		}
+
+		users, err := h.usernames()
+		if err != nil {
+			return err
+		}
+
+		title := fmt.Sprintf("Edit: %s", strconv.Quote(acc.RiotID()))
+		data := editPage{
+			page:         newPage(username, r),
+			Title:        title,
+			Users:        users,
+			Account:      *acc,
+			History:      history,
+			HidePassword: !sudo(currentSession(r), time.Now()),
+			Penalties:    penaltyForms(penalties),
+			PenaltyTypes: db.PenaltyTypes,
+			Appeals:      db.Appeals,
+		}
 		return h.Templates.ExecuteTemplate(w, templateEdit, data)
 	}
 
+	old, err := h.DB.Account(id)
+	if err != nil {
+		return badRequestf("couldn't get account with id %d from database, %v", id, err)
+	}
+
 	acc, err := accFromForm(r)
 	if err != nil {
 		return badRequestf("failed validating form input, %v", err)
 	}
+	acc.ID = id
+	if r.PostForm.Get("keep_password") == "true" {
+		acc.Password = old.Password
+	}
+	// The games may be counted down while the form is open,
+	// the count is only changed if the field was.
+	if orig := r.PostForm.Get("leaverbuster_games_orig"); orig == strconv.Itoa(acc.LeaverbusterGames) {
+		acc.LeaverbusterGames = old.LeaverbusterGames
+		if old.LeaverbusterGames == 0 && orig != "0" {
+			// Finishing the games removed the delay as well.
+			acc.Leaverbuster = old.Leaverbuster
+		}
+	}
+	now := time.Now()
+	startLeaverbuster(old, acc, now)
 
 	if err := h.DB.EditAccount(id, acc); err != nil {
 		if err == sql.ErrNoRows {
-			badRequestf("couldn't find account with id %d", id)
+			return badRequestf("couldn't find account with id %d", id)
 		}
 		return fmt.Errorf("writing account with id %d failed, %v", id, err)
 	}
 
-	http.Redirect(w, r, routeOverview, http.StatusSeeOther)
+	if err := h.recordPenalties(old, acc, now); err != nil {
+		return fmt.Errorf("adding penalties of account with id %d failed, %v", id, err)
+	}
+	for _, e := range editEvents(old, acc, username, now) {
+		if err := h.Events.Emit(e, acc); err != nil {
+			return fmt.Errorf("adding changes to history of account with id %d failed, %v", id, err)
+		}
+	}
+
+	moved := old.Region != acc.Region || old.IGN != acc.IGN || old.TagLine != acc.TagLine
+	if moved && acc.TrackPriority() != db.PriorityOff {
+		h.Updater.Queue(id)
+	}
+
+	if r.PostForm.Get("clear_review") == "true" {
+		if err := h.DB.EditReview(id, ""); err != nil {
+			return fmt.Errorf("clearing review flag of account with id %d failed, %v", id, err)
+		}
+		e := &db.Event{AccountID: id, Time: time.Now(), Kind: db.EventReview, Message: "Reviewed by " + username}
+		if err := h.Events.Emit(e, acc); err != nil {
+			return fmt.Errorf("adding review to history of account with id %d failed, %v", id, err)
+		}
+	}
+
+	redirectBack(w, r)
 	return nil
 }
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func TestEditLeaverbuster(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+	d := h.DB
+
+	now := time.Now()
+	acc := &db.Account{
+		Region:            "euw",
+		IGN:               "player0",
+		Leaverbuster:      10,
+		LeaverbusterGames: 5,
+		LeaverbusterSince: db.NullTime{Time: now, Valid: true},
+	}
+	if err := d.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	p := routeEdit + "/" + strconv.Itoa(acc.ID)
+
+	a := signIn(t, srv, "a")
+	token := csrfTokenOf(t, a, srv, p)
+	edit := func(games, orig string) {
+		form := url.Values{
+			"region":                  {"euw"},
+			"ign":                     {"player0"},
+			"leaverbuster":            {"10"},
+			"leaverbuster_games":      {games},
+			"leaverbuster_games_orig": {orig},
+			"track":                   {"0"},
+			"keep_password":           {"true"},
+			"csrf_token":              {token},
+		}
+		if status := post(t, a, srv, p, form); status != http.StatusSeeOther {
+			t.Fatalf("expected status %d, got %d", http.StatusSeeOther, status)
+		}
+	}
+	games := func() int {
+		acc, err := d.Account(acc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acc.LeaverbusterGames
+	}
+
+	// Two games are counted while the form with 5 games is open.
+	if _, err := d.PlayLeaverbuster(acc.ID, 2, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	edit("5", "5")
+	if got := games(); got != 3 {
+		t.Fatalf("unchanged field: expected the counted 3 games, got %d", got)
+	}
+	edit("7", "3")
+	if got := games(); got != 7 {
+		t.Fatalf("changed field: expected 7 games, got %d", got)
+	}
+}
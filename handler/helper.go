@@ -1,20 +1,79 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/erikfastermann/lam/db"
 )
 
-type editPage struct {
-	Title    string
-	Users    []string
+// page is embedded in the data of every template using the nav.
+type page struct {
 	Username string
-	Account  db.Account
+	Role     string
+	// CSRFToken has to be sent with every form, see csrfToken.
+	CSRFToken string
+}
+
+func newPage(username string, r *http.Request) page {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return page{Username: username, Role: currentRole(r), CSRFToken: token}
+}
+
+// Can reports whether the user has at least role,
+// templates use it to hide what the user isn't allowed to do.
+func (p page) Can(role string) bool {
+	return hasRole(p.Role, role)
+}
+
+type editPage struct {
+	page
+	Title   string
+	Users   []string
+	Account db.Account
+	History []*db.Event
+	// HidePassword is set outside of sudo mode,
+	// the form then keeps the stored password.
+	HidePassword bool
+
+	// Penalties ends with an empty form for a new penalty.
+	Penalties    []penaltyForm
+	PenaltyTypes []db.PenaltyType
+	Appeals      []db.Appeal
+}
+
+type penaltyForm struct {
+	db.Penalty
+	New         bool
+	From, Until string
+}
+
+func penaltyForms(penalties []*db.Penalty) []penaltyForm {
+	forms := make([]penaltyForm, 0)
+	for _, p := range penalties {
+		forms = append(forms, penaltyForm{
+			Penalty: *p,
+			From:    formatFormTime(p.Start),
+			Until:   formatFormTime(p.End),
+		})
+	}
+	return append(forms, penaltyForm{Penalty: db.Penalty{Type: db.PenaltyChat}, New: true})
+}
+
+// redirectBack redirects to the local path in the form value next,
+// or to the overview if there is none.
+func redirectBack(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !localPath(next) {
+		next = routeOverview
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
 }
 
 func accFromForm(r *http.Request) (*db.Account, error) {
@@ -32,7 +91,10 @@ func accFromForm(r *http.Request) (*db.Account, error) {
 	acc := new(db.Account)
 	acc.Region = formVal("region")
 	acc.Tag = formVal("tag")
-	acc.IGN = formVal("ign")
+	acc.IGN, acc.TagLine = splitRiotID(formVal("ign"), formVal("tagline"))
+	if err := validateRiotID(acc.IGN, acc.TagLine); err != nil {
+		return nil, err
+	}
 	acc.Username = formVal("username")
 	acc.Password = formVal("password")
 	acc.User = formVal("user")
@@ -43,17 +105,26 @@ func accFromForm(r *http.Request) (*db.Account, error) {
 		return nil, err
 	}
 	acc.Leaverbuster = leaverbusterInt
-
-	banForm := formVal("ban")
-	var ban db.NullTime
-	if banForm != "" {
-		ban.Time, err = time.ParseInLocation("2006-01-02 15:04", banForm, time.Local)
-		if err != nil {
-			return nil, err
+	if games := formVal("leaverbuster_games"); games != "" {
+		acc.LeaverbusterGames, err = strconv.Atoi(games)
+		if err != nil || acc.LeaverbusterGames < 0 {
+			return nil, fmt.Errorf("form-leaverbuster_games: invalid number of games %q", games)
 		}
-		ban.Valid = true
 	}
-	acc.Ban = ban
+
+	track, err := strconv.Atoi(formVal("track"))
+	if err != nil {
+		return nil, fmt.Errorf("form-track: %v", err)
+	}
+	acc.Track = db.Priority(track)
+	if acc.Track < db.PriorityAuto || acc.Track > db.PriorityHigh {
+		return nil, fmt.Errorf("form-track: unknown priority %d", track)
+	}
+
+	acc.Ban, err = parseFormTime(formVal("ban"))
+	if err != nil {
+		return nil, err
+	}
 
 	toBool := func(str string) (bool, error) {
 		if str == "true" {
@@ -83,12 +154,189 @@ func accFromForm(r *http.Request) (*db.Account, error) {
 	return acc, nil
 }
 
-func LeagueOfGraphsURL(region, ign string) string {
-	if region == "" || ign == "" {
+func penaltyFromForm(r *http.Request) (*db.Penalty, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	p := new(db.Penalty)
+	p.Type = db.PenaltyType(r.PostForm.Get("type"))
+	if !validPenaltyType(p.Type) {
+		return nil, fmt.Errorf("form-type: unknown penalty type %q", p.Type)
+	}
+	var err error
+	if p.Start, err = parseFormTime(r.PostForm.Get("start")); err != nil {
+		return nil, fmt.Errorf("form-start: %v", err)
+	}
+	if p.End, err = parseFormTime(r.PostForm.Get("end")); err != nil {
+		return nil, fmt.Errorf("form-end: %v", err)
+	}
+	if p.Start.Valid && p.End.Valid && p.End.Time.Before(p.Start.Time) {
+		return nil, errors.New("penalty ends before it starts")
+	}
+	p.Reason = strings.TrimSpace(r.PostForm.Get("reason"))
+	p.Appeal = db.Appeal(r.PostForm.Get("appeal"))
+	if !validAppeal(p.Appeal) {
+		return nil, fmt.Errorf("form-appeal: unknown appeal status %q", p.Appeal)
+	}
+	return p, nil
+}
+
+func validPenaltyType(t db.PenaltyType) bool {
+	for _, valid := range db.PenaltyTypes {
+		if t == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func validAppeal(a db.Appeal) bool {
+	for _, valid := range db.Appeals {
+		if a == valid {
+			return true
+		}
+	}
+	return false
+}
+
+const formTimeFormat = "2006-01-02 15:04"
+
+func formatFormTime(t db.NullTime) string {
+	if !t.Valid {
 		return ""
 	}
-	return fmt.Sprintf("https://www.leagueofgraphs.com/en/summoner/%s/%s",
-		url.PathEscape(region),
-		url.PathEscape(ign),
-	)
+	return t.Time.Local().Format(formTimeFormat)
+}
+
+// parseFormTime returns an invalid NullTime for an empty string.
+func parseFormTime(s string) (db.NullTime, error) {
+	if s == "" {
+		return db.NullTime{}, nil
+	}
+	t, err := time.ParseInLocation(formTimeFormat, s, time.Local)
+	if err != nil {
+		return db.NullTime{}, err
+	}
+	return db.NullTime{Time: t, Valid: true}, nil
+}
+
+// splitRiotID accepts the IGN either as gameName#tagLine
+// or with the tag line in its own field.
+func splitRiotID(ign, tagLine string) (string, string) {
+	ign, tagLine = strings.TrimSpace(ign), strings.TrimSpace(tagLine)
+	if i := strings.LastIndex(ign, "#"); i >= 0 {
+		if tagLine == "" {
+			tagLine = ign[i+1:]
+		}
+		ign = ign[:i]
+	}
+	return ign, strings.TrimPrefix(tagLine, "#")
+}
+
+func validateRiotID(name, tagLine string) error {
+	if name == "" {
+		if tagLine != "" {
+			return errors.New("tag line without IGN")
+		}
+		return nil
+	}
+	if n := utf8.RuneCountInString(name); n < 3 || n > 16 {
+		return fmt.Errorf("IGN %q must be between 3 and 16 characters long", name)
+	}
+	if strings.Contains(name, "#") {
+		return fmt.Errorf("IGN %q contains #", name)
+	}
+	if tagLine == "" {
+		return nil
+	}
+	if n := utf8.RuneCountInString(tagLine); n < 3 || n > 5 {
+		return fmt.Errorf("tag line %q must be between 3 and 5 characters long", tagLine)
+	}
+	for _, r := range tagLine {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return fmt.Errorf("tag line %q contains invalid character %q", tagLine, r)
+		}
+	}
+	return nil
+}
+
+func relativeAge(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+	}
+}
+
+func timeUntil(t, now time.Time) string {
+	d := t.Sub(now)
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		return fmt.Sprintf("in %dm", d/time.Minute)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("in %dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("in %dd %dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	}
+}
+
+// editEvents describes the changes made by username to old.
+func editEvents(old, acc *db.Account, username string, now time.Time) []*db.Event {
+	events := make([]*db.Event, 0)
+	add := func(kind, format string, a ...interface{}) {
+		events = append(events, &db.Event{
+			AccountID: acc.ID,
+			Time:      now,
+			Kind:      kind,
+			Message:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	banChanged := old.Ban.Valid != acc.Ban.Valid || !old.Ban.Time.Equal(acc.Ban.Time)
+	changed := make([]string, 0)
+	for _, f := range []struct {
+		name    string
+		changed bool
+	}{
+		{"region", old.Region != acc.Region},
+		{"tag", old.Tag != acc.Tag},
+		{"Riot ID", old.RiotID() != acc.RiotID()},
+		{"username", old.Username != acc.Username},
+		{"password", old.Password != acc.Password},
+		{"leaverbuster", old.Leaverbuster != acc.Leaverbuster || old.LeaverbusterGames != acc.LeaverbusterGames},
+		{"ban", banChanged},
+		{"perma", old.Perma != acc.Perma},
+		{"password changed", old.PasswordChanged != acc.PasswordChanged},
+		{"pre 30", old.Pre30 != acc.Pre30},
+		{"tracking", old.Track != acc.Track},
+	} {
+		if f.changed {
+			changed = append(changed, f.name)
+		}
+	}
+	if len(changed) > 0 {
+		add(db.EventEdit, "Edited by %s: %s", username, strings.Join(changed, ", "))
+	}
+
+	if acc.Perma && !old.Perma {
+		add(db.EventBan, "Permanently banned, set by %s", username)
+	} else if banChanged && acc.Ban.Valid && acc.Ban.Time.After(now) {
+		add(db.EventBan, "Banned until %s, set by %s", acc.Ban.Time.Local().Format("2 Jan 2006 15:04"), username)
+	}
+	if acc.PasswordChanged && !old.PasswordChanged {
+		add(db.EventPasswordChanged, "Password marked changed by %s", username)
+	}
+	if acc.User != old.User {
+		add(db.EventCheckout, "Checked out by %s, set by %s", acc.User, username)
+	}
+	return events
 }
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+// Only the newest events are shown in the feed.
+const feedLength = 100
+
+func (h *Handler) notifications(username string, w http.ResponseWriter, r *http.Request) error {
+	type event struct {
+		*db.Event
+		Class string
+		// Account is empty if the account doesn't exist anymore.
+		Account string
+	}
+	type notificationsPage struct {
+		page
+		Events []event
+	}
+
+	all, err := h.DB.AllEvents()
+	if err != nil {
+		return fmt.Errorf("couldn't read events from database, %v", err)
+	}
+	if len(all) > feedLength {
+		all = all[:feedLength]
+	}
+	accs, err := h.DB.Accounts()
+	if err != nil {
+		return fmt.Errorf("couldn't read accounts from database, %v", err)
+	}
+	names := make(map[int]string)
+	for _, acc := range accs {
+		names[acc.ID] = acc.RiotID()
+	}
+
+	events := make([]event, 0)
+	for _, e := range all {
+		events = append(events, event{e, eventClass(e.Kind), names[e.AccountID]})
+	}
+
+	data := notificationsPage{page: newPage(username, r), Events: events}
+	return h.Templates.ExecuteTemplate(w, templateNotifications, data)
+}
+
+func eventClass(kind string) string {
+	switch kind {
+	case db.EventPromotion, db.EventBanExpired:
+		return "badge-success"
+	case db.EventDemotion, db.EventDecay, db.EventBan:
+		return "badge-danger"
+	case db.EventReview, db.EventBanExpiring, db.EventLeaverbuster:
+		return "badge-warning"
+	case db.EventCheckout:
+		return "badge-info"
+	default:
+		return "badge-secondary"
+	}
+}
@@ -3,32 +3,88 @@ package handler
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/elo"
 )
 
+// Elo older than this is flagged on the overview.
+const eloStaleAfter = 7 * 24 * time.Hour
+
 func (h *Handler) overview(username string, w http.ResponseWriter, r *http.Request) error {
 	type account struct {
-		Color  string
-		Banned bool
-		Link   string
+		Color     string
+		Banned    bool
+		Link      string
+		Refresh   *badge
+		EloAge    string
+		EloStale  bool
+		Rank      string
+		Played    string
+		Decay     *badge
+		Penalties []*badge
 		db.Account
 	}
+	type expiry struct {
+		ID     int
+		RiotID string
+		Region string
+		Time   time.Time
+		In     string
+	}
 	type overviewPage struct {
-		Username string
-		Accounts []account
+		page
+		Accounts   []account
+		Expiries   []expiry
+		Refreshing bool
+		Queue      db.Queue
+		Queues     []db.Queue
+		// Next is the URL of the overview with the chosen queue,
+		// forms of the overview return to it.
+		Next string
+	}
+
+	queue := db.Queue(r.URL.Query().Get("queue"))
+	if queue == "" {
+		queue = db.QueueSolo
+	}
+	if !validQueue(queue) {
+		return badRequestf("unknown queue %q", queue)
 	}
 
-	db, err := h.DB.Accounts()
+	all, err := h.DB.Accounts()
 	if err != nil {
 		return fmt.Errorf("couldn't read accounts from database, %v", err)
 	}
 
+	penalties, err := h.DB.AllPenalties()
+	if err != nil {
+		return fmt.Errorf("couldn't read penalties from database, %v", err)
+	}
+	byAccount := make(map[int][]*db.Penalty)
+	for _, p := range penalties {
+		byAccount[p.AccountID] = append(byAccount[p.AccountID], p)
+	}
+
+	now := time.Now()
 	accs := make([]account, 0)
-	for _, acc := range db {
+	expiries := make([]expiry, 0)
+	refreshing := false
+	for _, acc := range all {
+		if !acc.Perma && acc.Ban.Valid && acc.Ban.Time.After(now) {
+			expiries = append(expiries, expiry{
+				ID:     acc.ID,
+				RiotID: acc.RiotID(),
+				Region: acc.Region,
+				Time:   acc.Ban.Time,
+				In:     timeUntil(acc.Ban.Time, now),
+			})
+		}
+
 		banned := false
-		if acc.Perma || (acc.Ban.Valid && acc.Ban.Time.After(time.Now())) {
+		if acc.Perma || (acc.Ban.Valid && acc.Ban.Time.After(now)) {
 			banned = true
 		}
 		color := ""
@@ -38,9 +94,95 @@ func (h *Handler) overview(username string, w http.ResponseWriter, r *http.Reque
 		if acc.Perma || acc.PasswordChanged {
 			color = "table-danger"
 		}
-		accs = append(accs, account{color, banned, LeagueOfGraphsURL(acc.Region, acc.IGN), *acc})
+		var refresh *badge
+		if res, ok := h.Updater.Result(acc.ID); ok {
+			refresh = refreshBadge(res)
+			refreshing = refreshing || res.InFlight()
+		}
+		eloAge := ""
+		eloStale := acc.Elo != "" || acc.EloFlex != "" || acc.EloTFT != ""
+		if acc.EloUpdated.Valid {
+			eloAge = relativeAge(acc.EloUpdated.Time, now)
+			eloStale = now.Sub(acc.EloUpdated.Time) > eloStaleAfter
+		}
+		played := ""
+		var decay *badge
+		if acc.LastPlayed.Valid {
+			played = relativeAge(acc.LastPlayed.Time, now)
+			decay = decayBadge(acc.Elo, acc.LastPlayed.Time, now)
+		}
+		accs = append(accs, account{
+			Color:     color,
+			Banned:    banned,
+			Link:      elo.LeagueOfGraphsURL(acc.Region, acc.IGN, acc.TagLine),
+			Refresh:   refresh,
+			EloAge:    eloAge,
+			EloStale:  eloStale,
+			Rank:      acc.Rank(queue),
+			Played:    played,
+			Decay:     decay,
+			Penalties: penaltyBadges(byAccount[acc.ID], now),
+			Account:   *acc,
+		})
 	}
 
-	data := overviewPage{Username: username, Accounts: accs}
+	sort.Slice(expiries, func(i, j int) bool {
+		return expiries[i].Time.Before(expiries[j].Time)
+	})
+
+	data := overviewPage{
+		page:       newPage(username, r),
+		Accounts:   accs,
+		Expiries:   expiries,
+		Refreshing: refreshing,
+		Queue:      queue,
+		Queues:     db.Queues,
+		Next:       r.URL.RequestURI(),
+	}
 	return h.Templates.ExecuteTemplate(w, templateOverview, data)
 }
+
+// Decay is shown this long before it starts.
+const decayWarnBefore = 7 * 24 * time.Hour
+
+func decayBadge(rank string, lastPlayed, now time.Time) *badge {
+	d, ok := elo.DecayIn(rank, lastPlayed, now)
+	switch {
+	case !ok || d > decayWarnBefore:
+		return nil
+	case d <= 0:
+		return &badge{Class: "badge-danger", Text: "decaying"}
+	default:
+		days := int(d / (24 * time.Hour))
+		return &badge{Class: "badge-warning", Text: fmt.Sprintf("decay in %dd", days)}
+	}
+}
+
+func validQueue(q db.Queue) bool {
+	for _, valid := range db.Queues {
+		if q == valid {
+			return true
+		}
+	}
+	return false
+}
+
+type badge struct {
+	Class, Text, Title string
+}
+
+func refreshBadge(res elo.Result) *badge {
+	b := &badge{Class: "badge-secondary", Text: res.Status.String()}
+	switch res.Status {
+	case elo.StatusUpdated:
+		b.Class = "badge-success"
+	case elo.StatusNotFound:
+		b.Class = "badge-warning"
+	case elo.StatusError:
+		b.Class = "badge-danger"
+		if res.Err != nil {
+			b.Title = res.Err.Error()
+		}
+	}
+	return b
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func (h *Handler) password(username string, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Path[1:])
+	if err != nil {
+		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
+	}
+
+	acc, err := h.DB.Account(id)
+	if err != nil {
+		return badRequestf("couldn't get account with id %d from database, %v", id, err)
+	}
+	// Viewers only use the accounts they checked out.
+	if !hasRole(currentRole(r), db.RoleEditor) && acc.User != username {
+		return errForbidden
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = fmt.Fprint(w, acc.Password)
+	return err
+}
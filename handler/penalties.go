@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func (h *Handler) played(username string, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Path[1:])
+	if err != nil {
+		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
+	}
+	acc, err := h.DB.Account(id)
+	if err != nil {
+		return badRequestf("couldn't get account with id %d from database, %v", id, err)
+	}
+
+	now := time.Now()
+	left, err := h.DB.PlayLeaverbuster(id, 1, now)
+	if err == db.ErrNoLeaverbuster {
+		return badRequestf("account with id %d has no leaverbuster games left", id)
+	}
+	if err != nil {
+		return fmt.Errorf("counting leaverbuster game of account with id %d failed, %v", id, err)
+	}
+
+	msg := fmt.Sprintf("Leaverbuster game played, %d left (marked by %s)", left, username)
+	if left == 0 {
+		msg = "Leaverbuster done, marked by " + username
+	}
+	e := &db.Event{AccountID: id, Time: now, Kind: db.EventLeaverbuster, Message: msg}
+	if err := h.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("adding leaverbuster game to history of account with id %d failed, %v", id, err)
+	}
+
+	redirectBack(w, r)
+	return nil
+}
+
+func (h *Handler) penalties(_ string, w http.ResponseWriter, r *http.Request) error {
+	accountID, err := strconv.Atoi(r.URL.Path[1:])
+	if err != nil {
+		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
+	}
+	if _, err := h.DB.Account(accountID); err != nil {
+		return badRequestf("couldn't get account with id %d from database, %v", accountID, err)
+	}
+	if err := r.ParseForm(); err != nil {
+		return badRequestf("failed parsing form, %v", err)
+	}
+
+	var id int
+	if idStr := r.PostForm.Get("id"); idStr != "" {
+		if id, err = strconv.Atoi(idStr); err != nil {
+			return badRequestf("couldn't parse penalty id %s", idStr)
+		}
+		old, err := h.DB.Penalty(id)
+		if err != nil || old.AccountID != accountID {
+			return badRequestf("couldn't find penalty with id %d of account with id %d", id, accountID)
+		}
+	}
+
+	if r.PostForm.Get("action") == "delete" {
+		if err := h.DB.RemovePenalty(id); err != nil {
+			return fmt.Errorf("removing penalty with id %d failed, %v", id, err)
+		}
+		http.Redirect(w, r, fmt.Sprintf("%s/%d", routeEdit, accountID), http.StatusSeeOther)
+		return nil
+	}
+
+	p, err := penaltyFromForm(r)
+	if err != nil {
+		return badRequestf("failed validating form input, %v", err)
+	}
+	p.ID, p.AccountID = id, accountID
+	if id == 0 {
+		err = h.DB.AddPenalty(p)
+	} else {
+		err = h.DB.EditPenalty(p)
+	}
+	if err != nil {
+		return fmt.Errorf("writing penalty of account with id %d failed, %v", accountID, err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s/%d", routeEdit, accountID), http.StatusSeeOther)
+	return nil
+}
+
+// startLeaverbuster starts counting the leaverbuster games of acc at now,
+// if they were set by an edit from old to acc.
+func startLeaverbuster(old, acc *db.Account, now time.Time) {
+	switch {
+	case acc.LeaverbusterGames == 0:
+		acc.LeaverbusterSince = db.NullTime{}
+	case old.LeaverbusterGames == 0:
+		acc.LeaverbusterSince = db.NullTime{Time: now, Valid: true}
+	default:
+		acc.LeaverbusterSince = old.LeaverbusterSince
+	}
+}
+
+// recordPenalties adds the bans and leaverbuster games set by an edit
+// from old to acc to the penalties. A changed end of a running ban updates its record.
+func (h *Handler) recordPenalties(old, acc *db.Account, now time.Time) error {
+	switch {
+	case acc.LeaverbusterGames > 0 && old.LeaverbusterGames == 0:
+		p := &db.Penalty{
+			AccountID: acc.ID,
+			Type:      db.PenaltyLeaverbuster,
+			Start:     db.NullTime{Time: now, Valid: true},
+			Reason:    fmt.Sprintf("%d games, %d min delay", acc.LeaverbusterGames, acc.Leaverbuster),
+		}
+		if err := h.DB.AddPenalty(p); err != nil {
+			return err
+		}
+	case acc.LeaverbusterGames == 0 && old.LeaverbusterGames > 0:
+		if err := h.DB.EndPenalties(acc.ID, db.PenaltyLeaverbuster, now); err != nil {
+			return err
+		}
+	}
+
+	if acc.Perma && !old.Perma {
+		p := &db.Penalty{
+			AccountID: acc.ID,
+			Type:      db.PenaltyPerma,
+			Start:     db.NullTime{Time: now, Valid: true},
+		}
+		if err := h.DB.AddPenalty(p); err != nil {
+			return err
+		}
+	}
+
+	changed := old.Ban.Valid != acc.Ban.Valid || !old.Ban.Time.Equal(acc.Ban.Time)
+	if !changed || !acc.Ban.Valid || !acc.Ban.Time.After(now) {
+		return nil
+	}
+	if old.Ban.Valid && old.Ban.Time.After(now) {
+		penalties, err := h.DB.Penalties(acc.ID)
+		if err != nil {
+			return err
+		}
+		for _, p := range penalties {
+			if p.Type == db.PenaltyTempBan && p.End.Valid && p.End.Time.Equal(old.Ban.Time) {
+				p.End = acc.Ban
+				return h.DB.EditPenalty(p)
+			}
+		}
+	}
+	p := &db.Penalty{
+		AccountID: acc.ID,
+		Type:      db.PenaltyTempBan,
+		Start:     db.NullTime{Time: now, Valid: true},
+		End:       acc.Ban,
+	}
+	return h.DB.AddPenalty(p)
+}
+
+// penaltyBadges summarizes the active penalties of an account
+// and counts the others.
+func penaltyBadges(penalties []*db.Penalty, now time.Time) []*badge {
+	badges := make([]*badge, 0)
+	past := 0
+	for _, p := range penalties {
+		if !p.Active(now) {
+			past++
+			continue
+		}
+		b := &badge{Class: "badge-danger", Text: p.Type.Name(), Title: p.Reason}
+		if p.End.Valid {
+			b.Text += " (" + timeUntil(p.End.Time, now) + ")"
+		}
+		if p.Appeal == db.AppealPending {
+			b.Class = "badge-warning"
+			b.Text += ", appealed"
+		}
+		badges = append(badges, b)
+	}
+	if past > 0 {
+		badges = append(badges, &badge{
+			Class: "badge-secondary",
+			Text:  fmt.Sprintf("%d past", past),
+			Title: "Past penalties",
+		})
+	}
+	return badges
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) refresh(_ string, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Path[1:])
+	if err != nil {
+		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
+	}
+
+	if _, err := h.DB.Account(id); err != nil {
+		return badRequestf("couldn't get account with id %d from database, %v", id, err)
+	}
+	h.Updater.Queue(id)
+
+	redirectBack(w, r)
+	return nil
+}
+
+func (h *Handler) refreshAll(_ string, w http.ResponseWriter, r *http.Request) error {
+	if err := h.Updater.QueueAll(); err != nil {
+		return fmt.Errorf("couldn't queue elo refresh, %v", err)
+	}
+
+	redirectBack(w, r)
+	return nil
+}
@@ -5,14 +5,31 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
 )
 
-func (h *Handler) remove(_ string, w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) remove(username string, w http.ResponseWriter, r *http.Request) error {
 	id, err := strconv.Atoi(r.URL.Path[1:])
 	if err != nil {
 		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
 	}
 
+	acc, err := h.DB.Account(id)
+	if err != nil {
+		return badRequestf("couldn't get account with id %d from database, %v", id, err)
+	}
+
+	if r.Method == http.MethodGet {
+		type removePage struct {
+			page
+			Account *db.Account
+		}
+		data := removePage{page: newPage(username, r), Account: acc}
+		return h.Templates.ExecuteTemplate(w, templateRemove, data)
+	}
+
 	if err := h.DB.RemoveAccount(id); err != nil {
 		if err == sql.ErrNoRows {
 			return badRequestf("couldn't find account with id %d", id)
@@ -20,6 +37,12 @@ func (h *Handler) remove(_ string, w http.ResponseWriter, r *http.Request) error
 		return fmt.Errorf("couldn't remove account with id %d, %v", id, err)
 	}
 
+	msg := fmt.Sprintf("Removed %s by %s", acc.RiotID(), username)
+	e := &db.Event{AccountID: id, Time: time.Now(), Kind: db.EventRemove, Message: msg}
+	if err := h.Events.Emit(e, acc); err != nil {
+		return fmt.Errorf("adding removal of account with id %d to history failed, %v", id, err)
+	}
+
 	http.Redirect(w, r, routeOverview, http.StatusSeeOther)
 	return nil
 }
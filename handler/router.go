@@ -1,63 +1,124 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/erikfastermann/httpwrap"
 	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/elo"
+	"github.com/erikfastermann/lam/notify"
 )
 
 const (
 	routeLogin    = "/login"
 	routeLogout   = "/logout"
+	routeConfirm  = "/confirm"
+	routeVerify   = "/verify"
 	routeOverview = "/"
 	routeEdit     = "/edit"
 	routeAdd      = "/add"
 	routeRemove   = "/remove"
+	routeCheckout = "/checkout"
+
+	routePenalties = "/penalties"
+	routePlayed    = "/played"
+
+	routeRefresh    = "/refresh"
+	routeRefreshAll = "/refresh-all"
+
+	routeNotifications = "/notifications"
+	routeWebhooks      = "/webhooks"
+	routeSubscriptions = "/subscriptions"
+
+	routeSessions  = "/sessions"
+	routeRevoke    = "/revoke"
+	routeRevokeAll = "/revoke-all"
+	routeAudit     = "/audit"
+	routeTwoFactor = "/2fa"
+
+	routeUsers          = "/users"
+	routeChangePassword = "/change-password"
+
+	routePassword = "/password"
 )
 
 const (
 	templateLogin    = "login.html"
+	templateConfirm  = "confirm.html"
+	templateVerify   = "verify.html"
 	templateOverview = "overview.html"
 	templateEdit     = "edit.html"
-)
+	templateRemove   = "remove.html"
 
-type User struct {
-	Username, Password, Token string
-}
+	templateNotifications = "notifications.html"
+	templateWebhooks      = "webhooks.html"
+	templateSubscriptions = "subscriptions.html"
+	templateSessions      = "sessions.html"
+	templateAudit         = "audit.html"
+	templateTwoFactor     = "twofactor.html"
+	templateUsers         = "users.html"
+
+	templateChangePassword = "change-password.html"
+)
 
 var errBadMethod = httpwrap.Error{
 	StatusCode: http.StatusMethodNotAllowed,
 	Err:        errors.New("bad method"),
 }
 
+var errForbidden = httpwrap.Error{
+	StatusCode: http.StatusForbidden,
+	Err:        errors.New("role isn't allowed to use the route"),
+}
+
+var errBadCSRF = httpwrap.Error{
+	StatusCode: http.StatusForbidden,
+	Err:        errors.New("missing or invalid CSRF token"),
+}
+
 type route struct {
 	remain  bool
 	methods []string
-	hf      handlerFunc
+	// role is the least role allowed to use the route, see db.Roles.
+	role string
+	// sudo routes require a recently confirmed password.
+	sudo bool
+	hf   handlerFunc
 }
 
 type handlerFunc func(username string, w http.ResponseWriter, r *http.Request) error
 
 type Handler struct {
-	DB *db.DB
+	DB      *db.DB
+	Updater *elo.Updater
+	Events  *notify.Dispatcher
 
-	mu    sync.RWMutex
-	Users []*User
+	// Webhooks is nil if no webhooks are configured.
+	Webhooks *notify.Webhooks
+	// Email is nil if no mail server is configured.
+	Email *notify.Email
+
+	// Sessions defaults to DB.
+	Sessions SessionStore
 
 	Templates *template.Template
 
 	once            sync.Once
 	logger          *log.Logger
 	protectedRoutes map[string]route
+	userThrottle    *throttle
+	ipThrottle      *throttle
 }
 
 func (h *Handler) ServeHTTPWithErr(w http.ResponseWriter, r *http.Request) error {
@@ -77,32 +138,93 @@ func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
 	header.Add("X-Content-Type-Options", "nosniff")
 	header.Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 
-	username, err := h.checkAuth(r)
+	s, u, err := h.checkAuth(w, r)
 	if path.Clean(r.URL.Path) == routeLogin {
 		if r.Method != http.MethodGet && r.Method != http.MethodPost {
 			return errBadMethod
 		}
+		username := ""
+		if s != nil {
+			username = s.Username
+		}
 		return h.login(username, w, r)
 	}
 	if err != nil {
 		http.Redirect(w, r, routeLogin, http.StatusSeeOther)
 		return err
 	}
+	c, err := r.Cookie(sessToken)
+	if err != nil {
+		return err
+	}
+	ctx := context.WithValue(r.Context(), sessionKey{}, s)
+	ctx = context.WithValue(ctx, roleKey{}, u.Role)
+	r = r.WithContext(context.WithValue(ctx, csrfKey{}, csrfToken(c.Value)))
 
-	fn, err := h.router(r)
+	if s.Pending {
+		if path.Clean(r.URL.Path) != routeVerify {
+			http.Redirect(w, r, routeVerify, http.StatusSeeOther)
+			return nil
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if !validCSRF(r) {
+				return errBadCSRF
+			}
+		default:
+			return errBadMethod
+		}
+		return h.verify(s, w, r)
+	}
+	required, err := h.requireTwoFactor()
 	if err != nil {
 		return err
 	}
-	return fn(username, w, r)
+	if required {
+		switch path.Clean(r.URL.Path) {
+		case routeTwoFactor, routeConfirm, routeLogout:
+		default:
+			enabled, err := h.twoFactorEnabled(s.Username)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				http.Redirect(w, r, routeTwoFactor, http.StatusSeeOther)
+				return nil
+			}
+		}
+	}
+
+	next := r.URL.RequestURI()
+	rt, err := h.router(r)
+	if err != nil {
+		return err
+	}
+	if r.Method != http.MethodGet && !validCSRF(r) {
+		return errBadCSRF
+	}
+	if !hasRole(u.Role, rt.role) {
+		return errForbidden
+	}
+	if rt.sudo && !sudo(s, time.Now()) {
+		if r.Method != http.MethodGet {
+			// The form can't be submitted again after confirming.
+			next = routeOverview
+		}
+		http.Redirect(w, r, routeConfirm+"?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return nil
+	}
+	return rt.hf(s.Username, w, r)
 }
 
-func (h *Handler) router(r *http.Request) (handlerFunc, error) {
+func (h *Handler) router(r *http.Request) (route, error) {
 	var base string
 	base, r.URL.Path = splitURL(r.URL.Path)
 
 	rt, ok := h.protectedRoutes[base]
 	if !ok || rt.remain != (r.URL.Path != "/") {
-		return nil, httpwrap.Error{
+		return route{}, httpwrap.Error{
 			StatusCode: http.StatusNotFound,
 			Err:        errors.New("unknown route"),
 		}
@@ -110,52 +232,156 @@ func (h *Handler) router(r *http.Request) (handlerFunc, error) {
 
 	for _, method := range rt.methods {
 		if method == r.Method {
-			return rt.hf, nil
+			return rt, nil
 		}
 	}
-	return nil, errBadMethod
+	return route{}, errBadMethod
 }
 
 func (h *Handler) setup() {
 	h.logger = log.New(os.Stderr, "ERROR ", log.LstdFlags)
+	if h.Sessions == nil {
+		h.Sessions = h.DB
+	}
+	h.userThrottle = newThrottle(maxUserFailures)
+	h.ipThrottle = newThrottle(maxIPFailures)
 
 	h.protectedRoutes = map[string]route{
 		routeLogout: {
-			false,
-			[]string{http.MethodGet},
-			h.logout,
+			methods: []string{http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.logout,
+		},
+		routeConfirm: {
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.confirm,
 		},
 		routeOverview: {
-			false,
-			[]string{http.MethodGet},
-			h.overview,
+			methods: []string{http.MethodGet},
+			role:    db.RoleViewer,
+			hf:      h.overview,
 		},
 		routeAdd: {
-			false,
-			[]string{http.MethodGet, http.MethodPost},
-			h.add,
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleEditor,
+			hf:      h.add,
+		},
+		routeCheckout: {
+			remain:  true,
+			methods: []string{http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.checkout,
 		},
 		routeEdit: {
-			true,
-			[]string{http.MethodGet, http.MethodPost},
-			h.edit,
+			remain:  true,
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleEditor,
+			hf:      h.edit,
 		},
 		routeRemove: {
-			true,
-			[]string{http.MethodGet},
-			h.remove,
+			remain:  true,
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleAdmin,
+			sudo:    true,
+			hf:      h.remove,
+		},
+		routeTwoFactor: {
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleViewer,
+			sudo:    true,
+			hf:      h.twoFactor,
+		},
+		routeAudit: {
+			methods: []string{http.MethodGet},
+			role:    db.RoleAdmin,
+			hf:      h.audit,
+		},
+		routeUsers: {
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleAdmin,
+			sudo:    true,
+			hf:      h.users,
+		},
+		routeChangePassword: {
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.changePassword,
+		},
+		routePassword: {
+			remain:  true,
+			methods: []string{http.MethodGet},
+			role:    db.RoleViewer,
+			sudo:    true,
+			hf:      h.password,
+		},
+		routePenalties: {
+			remain:  true,
+			methods: []string{http.MethodPost},
+			role:    db.RoleEditor,
+			hf:      h.penalties,
+		},
+		routePlayed: {
+			remain:  true,
+			methods: []string{http.MethodPost},
+			role:    db.RoleEditor,
+			hf:      h.played,
+		},
+		routeRefresh: {
+			remain:  true,
+			methods: []string{http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.refresh,
+		},
+		routeRefreshAll: {
+			methods: []string{http.MethodPost},
+			role:    db.RoleEditor,
+			hf:      h.refreshAll,
+		},
+		routeNotifications: {
+			methods: []string{http.MethodGet},
+			role:    db.RoleViewer,
+			hf:      h.notifications,
+		},
+		routeWebhooks: {
+			methods: []string{http.MethodGet},
+			role:    db.RoleAdmin,
+			hf:      h.webhooks,
+		},
+		routeSubscriptions: {
+			methods: []string{http.MethodGet, http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.subscriptions,
+		},
+		routeSessions: {
+			methods: []string{http.MethodGet},
+			role:    db.RoleViewer,
+			hf:      h.sessions,
+		},
+		routeRevoke: {
+			remain:  true,
+			methods: []string{http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.revoke,
+		},
+		routeRevokeAll: {
+			methods: []string{http.MethodPost},
+			role:    db.RoleViewer,
+			hf:      h.revokeAll,
 		},
 	}
 }
 
-func (h *Handler) usernames() []string {
+func (h *Handler) usernames() ([]string, error) {
+	users, err := h.DB.Users()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read users from database, %v", err)
+	}
 	usernames := make([]string, 0)
-	h.mu.RLock()
-	for _, u := range h.Users {
+	for _, u := range users {
 		usernames = append(usernames, u.Username)
 	}
-	h.mu.RUnlock()
-	return usernames
+	return usernames, nil
 }
 
 func splitURL(url string) (string, string) {
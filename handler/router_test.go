@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/httpwrap"
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/elo"
+	"github.com/erikfastermann/lam/notify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testServer serves a Handler with the admins a and b,
+// both with the password "pw". stop has to be called after the test.
+func testServer(t *testing.T) (srv *httptest.Server, h *Handler, stop func()) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Init(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"a", "b"} {
+		u := &db.User{Username: username, Password: string(hash), Created: time.Now(), Role: db.RoleAdmin}
+		if err := d.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := &notify.Dispatcher{DB: d}
+	h = &Handler{
+		DB:        d,
+		Updater:   &elo.Updater{DB: d, Events: events},
+		Events:    events,
+		Templates: template.Must(template.ParseGlob("../template/*.html")),
+	}
+	srv = httptest.NewServer(httpwrap.HandleError(h))
+	return srv, h, func() {
+		srv.Close()
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// signIn returns a client with a session of username.
+// It doesn't follow redirects.
+func signIn(t *testing.T, srv *httptest.Server, username string) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := c.PostForm(srv.URL+routeLogin, url.Values{"username": {username}, "password": {"pw"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || len(jar.Cookies(res.Request.URL)) == 0 {
+		t.Fatalf("signing in as %s failed with status %d", username, res.StatusCode)
+	}
+	return c
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// csrfTokenOf returns the CSRF token of the form on the page at p.
+func csrfTokenOf(t *testing.T, c *http.Client, srv *httptest.Server, p string) string {
+	res, err := c.Get(srv.URL + p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := csrfInput.FindSubmatch(b)
+	if m == nil {
+		t.Fatalf("no CSRF token on %s (status %d)", p, res.StatusCode)
+	}
+	return string(m[1])
+}
+
+func get(t *testing.T, c *http.Client, srv *httptest.Server, p string) int {
+	res, err := c.Get(srv.URL + p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func post(t *testing.T, c *http.Client, srv *httptest.Server, p string, form url.Values) int {
+	res, err := c.PostForm(srv.URL+p, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestCSRF(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+	d := h.DB
+	a := signIn(t, srv, "a")
+	b := signIn(t, srv, "b")
+	token := csrfTokenOf(t, a, srv, routeAdd)
+
+	account := func(csrf string) url.Values {
+		return url.Values{
+			"region":       {"euw"},
+			"ign":          {"player"},
+			"leaverbuster": {"0"},
+			"track":        {"0"},
+			"csrf_token":   {csrf},
+		}
+	}
+	forged := []struct {
+		name  string
+		token string
+	}{
+		{"missing", ""},
+		{"invalid", "forged"},
+		{"other session", csrfTokenOf(t, b, srv, routeAdd)},
+	}
+	for _, f := range forged {
+		if status := post(t, a, srv, routeAdd, account(f.token)); status != http.StatusForbidden {
+			t.Errorf("%s token: expected status %d, got %d", f.name, http.StatusForbidden, status)
+		}
+	}
+	accs, err := d.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 0 {
+		t.Fatalf("forged requests added %d accounts", len(accs))
+	}
+
+	if status := post(t, a, srv, routeAdd, account(token)); status != http.StatusSeeOther {
+		t.Fatalf("expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	accs, err = d.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 1 {
+		t.Fatalf("expected 1 account, got %d", len(accs))
+	}
+
+	remove := routeRemove + "/1"
+	res, err := a.Get(srv.URL + remove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: expected status %d, got %d", remove, http.StatusOK, res.StatusCode)
+	}
+	if status := post(t, a, srv, remove, nil); status != http.StatusForbidden {
+		t.Fatalf("POST %s without token: expected status %d, got %d", remove, http.StatusForbidden, status)
+	}
+	if _, err := d.Account(1); err != nil {
+		t.Fatalf("account removed without confirmation, %v", err)
+	}
+	if status := post(t, a, srv, remove, url.Values{"csrf_token": {token}}); status != http.StatusSeeOther {
+		t.Fatalf("POST %s: expected status %d, got %d", remove, http.StatusSeeOther, status)
+	}
+	if _, err := d.Account(1); err == nil {
+		t.Fatal("account wasn't removed")
+	}
+
+	if status := post(t, a, srv, routeLogout, nil); status != http.StatusForbidden {
+		t.Fatalf("logout without token: expected status %d, got %d", http.StatusForbidden, status)
+	}
+	res, err = a.Get(srv.URL + routeOverview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("forged logout ended the session, got status %d", res.StatusCode)
+	}
+}
+
+func TestRedirectBack(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+
+	acc := &db.Account{Region: "euw", IGN: "player0"}
+	if err := h.DB.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	a := signIn(t, srv, "a")
+
+	const overview = "/?queue=flex"
+	res, err := a.Get(srv.URL + overview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `name="next" value="/?queue=flex"`) {
+		t.Fatal("the forms of the overview don't return to the chosen queue")
+	}
+	token := csrfTokenOf(t, a, srv, overview)
+
+	refresh := routeRefresh + "/" + strconv.Itoa(acc.ID)
+	for next, want := range map[string]string{
+		overview:         overview,
+		"":               routeOverview,
+		"//example.com/": routeOverview,
+	} {
+		res, err := a.PostForm(srv.URL+refresh, url.Values{"csrf_token": {token}, "next": {next}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if loc := res.Header.Get("Location"); loc != want {
+			t.Errorf("next %q: expected redirect to %q, got %q", next, want, loc)
+		}
+	}
+}
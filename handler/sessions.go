@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func (h *Handler) sessions(username string, w http.ResponseWriter, r *http.Request) error {
+	type session struct {
+		*db.Session
+		Device  string
+		Active  string
+		Current bool
+	}
+	type sessionsPage struct {
+		page
+		Sessions []session
+	}
+
+	all, err := h.Sessions.Sessions(username)
+	if err != nil {
+		return fmt.Errorf("couldn't read sessions of %s, %v", username, err)
+	}
+	current := currentSession(r).TokenHash
+	now := time.Now()
+	sessions := make([]session, 0)
+	for _, s := range all {
+		if expired(s, now) {
+			continue
+		}
+		sessions = append(sessions, session{
+			Session: s,
+			Device:  describeUserAgent(s.UserAgent),
+			Active:  relativeAge(s.LastSeen, now),
+			Current: s.TokenHash == current,
+		})
+	}
+
+	data := sessionsPage{page: newPage(username, r), Sessions: sessions}
+	return h.Templates.ExecuteTemplate(w, templateSessions, data)
+}
+
+func (h *Handler) revoke(username string, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Path[1:])
+	if err != nil {
+		return badRequestf("couldn't parse id %s", r.URL.Path[1:])
+	}
+
+	sessions, err := h.Sessions.Sessions(username)
+	if err != nil {
+		return fmt.Errorf("couldn't read sessions of %s, %v", username, err)
+	}
+	for _, s := range sessions {
+		if s.ID != id {
+			continue
+		}
+		if err := h.Sessions.RemoveSession(s.TokenHash); err != nil {
+			return fmt.Errorf("couldn't remove session with id %d, %v", id, err)
+		}
+		http.Redirect(w, r, routeSessions, http.StatusSeeOther)
+		return nil
+	}
+	return badRequestf("%s has no session with id %d", username, id)
+}
+
+func (h *Handler) revokeAll(username string, w http.ResponseWriter, r *http.Request) error {
+	if err := h.Sessions.RemoveSessions(username); err != nil {
+		return fmt.Errorf("couldn't remove sessions of %s, %v", username, err)
+	}
+	http.Redirect(w, r, routeLogin, http.StatusSeeOther)
+	return nil
+}
+
+// describeUserAgent returns the browser and operating system of ua.
+func describeUserAgent(ua string) string {
+	find := func(candidates [][2]string) string {
+		for _, c := range candidates {
+			if strings.Contains(ua, c[0]) {
+				return c[1]
+			}
+		}
+		return ""
+	}
+	// The order matters, most browsers claim to be others as well.
+	browser := find([][2]string{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	})
+	os := find([][2]string{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	})
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
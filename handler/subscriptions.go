@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/mail"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/notify"
+)
+
+func (h *Handler) subscriptions(username string, w http.ResponseWriter, r *http.Request) error {
+	type topic struct {
+		notify.Topic
+		Checked bool
+	}
+	type subscriptionsPage struct {
+		page
+		Configured bool
+		Email      string
+		Topics     []topic
+	}
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			return badRequestf("failed parsing form, %v", err)
+		}
+		s := &db.Subscription{Username: username, Topics: make([]string, 0)}
+		if email := r.PostForm.Get("email"); email != "" {
+			addr, err := mail.ParseAddress(email)
+			if err != nil {
+				return badRequestf("invalid email address %q, %v", email, err)
+			}
+			s.Email = addr.Address
+		}
+		for _, t := range notify.Topics {
+			if r.PostForm.Get(t.Name) == "true" {
+				s.Topics = append(s.Topics, t.Name)
+			}
+		}
+		if err := h.DB.SetSubscription(s); err != nil {
+			return fmt.Errorf("writing subscription of %s failed, %v", username, err)
+		}
+		http.Redirect(w, r, routeSubscriptions, http.StatusSeeOther)
+		return nil
+	}
+
+	s, err := h.DB.Subscription(username)
+	if err == sql.ErrNoRows {
+		s = &db.Subscription{Username: username}
+	} else if err != nil {
+		return fmt.Errorf("couldn't read subscription of %s from database, %v", username, err)
+	}
+
+	data := subscriptionsPage{page: newPage(username, r), Configured: h.Email != nil, Email: s.Email}
+	for _, t := range notify.Topics {
+		data.Topics = append(data.Topics, topic{t, s.Has(t.Name)})
+	}
+	return h.Templates.ExecuteTemplate(w, templateSubscriptions, data)
+}
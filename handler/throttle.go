@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Failed sign ins are delayed progressively after freeFailures,
+	// starting at failureDelay and doubling up to maxFailureDelay.
+	freeFailures    = 3
+	failureDelay    = time.Second
+	maxFailureDelay = time.Minute
+	// Failures are forgotten after failureWindow without one.
+	failureWindow = time.Hour
+	// lockoutDuration is how long sign ins are locked
+	// after too many failures.
+	lockoutDuration = 15 * time.Minute
+	// Many users can share an IP, so it's allowed more failures.
+	maxUserFailures = 10
+	maxIPFailures   = 50
+	// Forgotten failures are removed once there are more keys than this.
+	pruneAbove = 10000
+)
+
+// throttle limits failed sign ins per key.
+type throttle struct {
+	// max is the number of failures that lock the key.
+	max int
+
+	mu       sync.Mutex
+	failures map[string]*failures
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newThrottle(max int) *throttle {
+	return &throttle{max: max, failures: make(map[string]*failures)}
+}
+
+// get returns the failures of key at now, nil if they were forgotten.
+// t.mu has to be held.
+func (t *throttle) get(key string, now time.Time) *failures {
+	f, ok := t.failures[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(f.last) > failureWindow && !now.Before(f.lockedUntil) {
+		delete(t.failures, key)
+		return nil
+	}
+	return f
+}
+
+// allow reports whether key may attempt to sign in at now.
+func (t *throttle) allow(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f := t.get(key, now)
+	if f == nil {
+		return true
+	}
+	if now.Before(f.lockedUntil) {
+		return false
+	}
+	return !now.Before(f.last.Add(delay(f.count)))
+}
+
+// fail records a failed sign in of key at now
+// and reports whether key got locked because of it.
+func (t *throttle) fail(key string, now time.Time) (locked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.failures) > pruneAbove {
+		for k := range t.failures {
+			t.get(k, now)
+		}
+	}
+	f := t.get(key, now)
+	if f == nil {
+		f = new(failures)
+		t.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < t.max {
+		return false
+	}
+	f.count = 0
+	f.lockedUntil = now.Add(lockoutDuration)
+	return true
+}
+
+func (t *throttle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+// delay returns the time to wait after count failures.
+func delay(count int) time.Duration {
+	if count < freeFailures {
+		return 0
+	}
+	d := failureDelay
+	for i := freeFailures; i < count && d < maxFailureDelay; i++ {
+		d *= 2
+	}
+	if d > maxFailureDelay {
+		d = maxFailureDelay
+	}
+	return d
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(5)
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < freeFailures; i++ {
+		if !th.allow("a", now) {
+			t.Fatalf("failure %d: expected no delay", i)
+		}
+		th.fail("a", now)
+	}
+	if th.allow("a", now) || th.allow("a", now.Add(failureDelay-time.Millisecond)) {
+		t.Fatal("expected a delay after the free failures")
+	}
+	now = now.Add(failureDelay)
+	if !th.allow("a", now) || !th.allow("b", now) {
+		t.Fatal("expected sign in after the delay")
+	}
+	if th.fail("a", now) {
+		t.Fatal("locked too early")
+	}
+	if th.allow("a", now.Add(failureDelay)) || !th.allow("a", now.Add(2*failureDelay)) {
+		t.Fatal("expected the delay to double")
+	}
+
+	now = now.Add(2 * failureDelay)
+	if !th.fail("a", now) {
+		t.Fatal("expected lockout")
+	}
+	if th.allow("a", now.Add(lockoutDuration-time.Second)) {
+		t.Fatal("allowed sign in while locked")
+	}
+	if !th.allow("a", now.Add(lockoutDuration)) {
+		t.Fatal("still locked after the lockout")
+	}
+
+	th.fail("b", now)
+	th.reset("b")
+	if !th.allow("b", now) {
+		t.Fatal("failures kept after reset")
+	}
+	th.fail("c", now)
+	if th.get("c", now.Add(failureWindow+time.Second)) != nil {
+		t.Fatal("failures kept after the window")
+	}
+
+	if d := delay(100); d != maxFailureDelay {
+		t.Fatalf("expected the delay to be capped at %v, got %v", maxFailureDelay, d)
+	}
+}
+
+var noRedirect = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func TestLoginFailed(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+	d := h.DB
+
+	// Unknown usernames and wrong passwords can't be told apart.
+	for _, username := range []string{"a", "unknown"} {
+		res, err := noRedirect.PostForm(srv.URL+routeLogin, url.Values{"username": {username}, "password": {"wrong"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if loc := res.Header.Get("Location"); res.StatusCode != http.StatusSeeOther || loc != routeLogin+"?failed=1" {
+			t.Fatalf("%s: expected redirect to the failed login, got %d %s", username, res.StatusCode, loc)
+		}
+		if len(res.Cookies()) != 0 {
+			t.Fatalf("%s: got a cookie for a failed login", username)
+		}
+	}
+
+	entries, err := d.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected an empty audit log, got %+v", entries)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	h := &Handler{DB: d}
+	h.once.Do(h.setup)
+	now := time.Now()
+	for i := 0; i < maxUserFailures; i++ {
+		if err := h.loginFailed("me", "192.0.2.1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := d.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != db.AuditLockout || entries[0].Username != "me" || entries[0].IP != "192.0.2.1" {
+		t.Fatalf("expected the lockout of me in the audit log, got %+v", entries)
+	}
+	if h.userThrottle.allow("me", now.Add(maxFailureDelay)) {
+		t.Fatal("username not locked")
+	}
+	if !h.ipThrottle.allow("192.0.2.1", now.Add(maxFailureDelay)) {
+		t.Fatal("IP locked too early")
+	}
+}
+
+func TestConfirmThrottle(t *testing.T) {
+	srv, _, stop := testServer(t)
+	defer stop()
+
+	a := signIn(t, srv, "a")
+	token := csrfTokenOf(t, a, srv, routeConfirm)
+	confirm := func(password string) int {
+		return post(t, a, srv, routeConfirm, url.Values{"csrf_token": {token}, "password": {password}})
+	}
+	if status := confirm("pw"); status != http.StatusSeeOther {
+		t.Fatalf("expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	for i := 0; i < freeFailures; i++ {
+		if status := confirm("wrong"); status == http.StatusSeeOther {
+			t.Fatalf("failure %d: confirmed a wrong password", i)
+		}
+	}
+	// Confirmed pages redirect to next.
+	if status := confirm("pw"); status == http.StatusSeeOther {
+		t.Fatal("confirmed while throttled")
+	}
+}
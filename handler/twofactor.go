@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/totp"
+	"rsc.io/qr"
+)
+
+const (
+	// totpIssuer is shown in authenticator apps.
+	totpIssuer        = "LAM"
+	recoveryCodeCount = 10
+)
+
+// requireTwoFactor reports whether users without two-factor authentication
+// are sent to its setup. Admins change it on the users page.
+func (h *Handler) requireTwoFactor() (bool, error) {
+	v, err := h.DB.Setting(db.SettingRequireTwoFactor)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("couldn't read setting %s, %v", db.SettingRequireTwoFactor, err)
+	}
+	return v == "true", nil
+}
+
+// twoFactorEnabled reports whether username signs in with a second factor.
+func (h *Handler) twoFactorEnabled(username string) (bool, error) {
+	tf, err := h.DB.TwoFactor(username)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("couldn't read two-factor authentication of %s, %v", username, err)
+	}
+	return tf.Enabled, nil
+}
+
+func (h *Handler) twoFactor(username string, w http.ResponseWriter, r *http.Request) error {
+	type twoFactorPage struct {
+		page
+		Required bool
+		Enabled  bool
+		// Secret and QR are set during the setup.
+		Secret string
+		QR     template.URL
+		Failed bool
+		// RecoveryCodes are only shown once after they were generated.
+		RecoveryCodes []string
+		Remaining     int
+	}
+
+	tf, err := h.DB.TwoFactor(username)
+	if err == sql.ErrNoRows {
+		tf = nil
+	} else if err != nil {
+		return fmt.Errorf("couldn't read two-factor authentication of %s, %v", username, err)
+	}
+	required, err := h.requireTwoFactor()
+	if err != nil {
+		return err
+	}
+	data := twoFactorPage{page: newPage(username, r), Required: required}
+	render := func() error {
+		if tf != nil {
+			data.Enabled = tf.Enabled
+			data.Remaining = len(tf.RecoveryCodes)
+			if !tf.Enabled {
+				data.Secret = tf.Secret
+				data.QR, err = qrDataURL(totp.URL(totpIssuer, username, tf.Secret))
+				if err != nil {
+					return fmt.Errorf("couldn't encode QR code, %v", err)
+				}
+			}
+		}
+		return h.Templates.ExecuteTemplate(w, templateTwoFactor, data)
+	}
+	if r.Method == http.MethodGet {
+		return render()
+	}
+
+	audit := func(kind, msg string) error {
+		e := &db.AuditEntry{Time: time.Now(), Kind: kind, Username: username, IP: remoteIP(r), Message: msg}
+		if err := h.DB.AddAudit(e); err != nil {
+			return fmt.Errorf("couldn't add %s of %s to audit log, %v", kind, username, err)
+		}
+		return nil
+	}
+
+	switch action := r.PostForm.Get("action"); action {
+	case "setup":
+		secret, err := totp.NewSecret()
+		if err != nil {
+			return err
+		}
+		tf = &db.TwoFactor{Username: username, Secret: secret, RecoveryCodes: make([]string, 0)}
+		if err := h.DB.SetTwoFactor(tf); err != nil {
+			return fmt.Errorf("couldn't store two-factor secret of %s, %v", username, err)
+		}
+		http.Redirect(w, r, routeTwoFactor, http.StatusSeeOther)
+		return nil
+	case "enable":
+		if tf == nil || tf.Enabled {
+			return badRequestf("%s has no two-factor setup in progress", username)
+		}
+		counter, ok := totp.Verify(tf.Secret, r.PostForm.Get("code"), time.Now())
+		if !ok {
+			data.Failed = true
+			return render()
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		tf.Enabled = true
+		tf.LastCounter = counter
+		tf.RecoveryCodes = hashes
+		if err := h.DB.SetTwoFactor(tf); err != nil {
+			return fmt.Errorf("couldn't enable two-factor authentication of %s, %v", username, err)
+		}
+		if err := audit(db.AuditTwoFactorEnabled, "Two-factor authentication enabled"); err != nil {
+			return err
+		}
+		data.RecoveryCodes = codes
+		return render()
+	case "recovery":
+		if tf == nil || !tf.Enabled {
+			return badRequestf("two-factor authentication of %s isn't enabled", username)
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		tf.RecoveryCodes = hashes
+		if err := h.DB.SetTwoFactor(tf); err != nil {
+			return fmt.Errorf("couldn't store recovery codes of %s, %v", username, err)
+		}
+		if err := audit(db.AuditRecoveryCodes, "New recovery codes generated"); err != nil {
+			return err
+		}
+		data.RecoveryCodes = codes
+		return render()
+	case "disable":
+		if tf == nil {
+			return badRequestf("%s has no two-factor authentication", username)
+		}
+		if tf.Enabled && required {
+			return badRequestf("two-factor authentication is required")
+		}
+		if err := h.DB.RemoveTwoFactor(username); err != nil {
+			return fmt.Errorf("couldn't remove two-factor authentication of %s, %v", username, err)
+		}
+		if tf.Enabled {
+			if err := audit(db.AuditTwoFactorDisabled, "Two-factor authentication disabled"); err != nil {
+				return err
+			}
+		}
+		http.Redirect(w, r, routeTwoFactor, http.StatusSeeOther)
+		return nil
+	default:
+		return badRequestf("unknown action %q", action)
+	}
+}
+
+// verify is the second step of the sign in for pending sessions.
+func (h *Handler) verify(s *db.Session, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodGet {
+		type verifyPage struct {
+			CSRFToken string
+			Failed    bool
+		}
+		data := verifyPage{
+			CSRFToken: newPage(s.Username, r).CSRFToken,
+			Failed:    r.URL.Query().Get("failed") != "",
+		}
+		return h.Templates.ExecuteTemplate(w, templateVerify, data)
+	}
+
+	if r.PostForm.Get("action") == "cancel" {
+		if err := h.Sessions.RemoveSession(s.TokenHash); err != nil {
+			return fmt.Errorf("couldn't remove pending session of %s, %v", s.Username, err)
+		}
+		http.SetCookie(w, sessionCookie(r, "", -1))
+		http.Redirect(w, r, routeLogin, http.StatusSeeOther)
+		return nil
+	}
+
+	ip := remoteIP(r)
+	now := time.Now()
+	if !h.userThrottle.allow(s.Username, now) || !h.ipThrottle.allow(ip, now) {
+		http.Redirect(w, r, routeVerify+"?failed=1", http.StatusSeeOther)
+		return errLoginFailed
+	}
+	ok, err := h.secondFactor(s.Username, ip, r.PostForm.Get("code"), now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := h.loginFailed(s.Username, ip, now); err != nil {
+			return err
+		}
+		http.Redirect(w, r, routeVerify+"?failed=1", http.StatusSeeOther)
+		return errLoginFailed
+	}
+	h.userThrottle.reset(s.Username)
+
+	if err := h.Sessions.VerifySession(s.TokenHash, now); err != nil {
+		return fmt.Errorf("couldn't verify session of %s, %v", s.Username, err)
+	}
+	http.Redirect(w, r, routeOverview, http.StatusSeeOther)
+	return nil
+}
+
+// secondFactor reports whether code is an unused TOTP or recovery code of username.
+func (h *Handler) secondFactor(username, ip, code string, now time.Time) (bool, error) {
+	tf, err := h.DB.TwoFactor(username)
+	if err == sql.ErrNoRows {
+		// Disabled after the password was entered.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("couldn't read two-factor authentication of %s, %v", username, err)
+	}
+
+	if counter, ok := totp.Verify(tf.Secret, code, now); ok {
+		used, err := h.DB.UseTwoFactorCounter(username, counter)
+		if err != nil {
+			return false, fmt.Errorf("couldn't store used code of %s, %v", username, err)
+		}
+		return used, nil
+	}
+
+	used, err := h.DB.UseRecoveryCode(username, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, fmt.Errorf("couldn't remove recovery code of %s, %v", username, err)
+	}
+	if !used {
+		return false, nil
+	}
+	msg := fmt.Sprintf("Signed in with a recovery code, %d left", len(tf.RecoveryCodes)-1)
+	e := &db.AuditEntry{Time: now, Kind: db.AuditRecoveryCodeUsed, Username: username, IP: ip, Message: msg}
+	if err := h.DB.AddAudit(e); err != nil {
+		return false, fmt.Errorf("couldn't add recovery code of %s to audit log, %v", username, err)
+	}
+	return true, nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns recoveryCodeCount codes like abcd-efgh
+// and their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, 0)
+	hashes = make([]string, 0)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes = append(codes, c[:4]+"-"+c[4:])
+		hashes = append(hashes, hashToken(c))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.Replace(code, "-", "", -1)
+}
+
+// qrDataURL returns a PNG of the QR code of text as data URL.
+func qrDataURL(text string) (template.URL, error) {
+	c, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+	png := base64.StdEncoding.EncodeToString(c.PNG())
+	return template.URL("data:image/png;base64," + png), nil
+}
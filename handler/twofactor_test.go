@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"github.com/erikfastermann/lam/totp"
+)
+
+var recoveryCode = regexp.MustCompile(`<li>([a-z2-7]{4}-[a-z2-7]{4})</li>`)
+
+// location returns the redirect target of a GET of p.
+func location(t *testing.T, c *http.Client, srv, p string) string {
+	res, err := c.Get(srv + p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.Header.Get("Location")
+}
+
+func TestTwoFactor(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+
+	a := signIn(t, srv, "a")
+	token := csrfTokenOf(t, a, srv, routeTwoFactor)
+	form := func(action, code string) url.Values {
+		return url.Values{"csrf_token": {token}, "action": {action}, "code": {code}}
+	}
+	if status := post(t, a, srv, routeTwoFactor, form("setup", "")); status != http.StatusSeeOther {
+		t.Fatalf("setup: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	tf, err := h.DB.TwoFactor("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.Enabled {
+		t.Fatal("enabled before a code was entered")
+	}
+	if loc := location(t, signIn(t, srv, "a"), srv.URL, routeOverview); loc != "" {
+		t.Fatalf("second factor required before it was enabled, redirected to %s", loc)
+	}
+
+	now := time.Now()
+	code, err := totp.Code(tf.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := a.PostForm(srv.URL+routeTwoFactor, form("enable", code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := recoveryCode.FindAllSubmatch(body, -1)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	verify := func(c *http.Client, code string) string {
+		token := csrfTokenOf(t, c, srv, routeVerify)
+		res, err := c.PostForm(srv.URL+routeVerify, url.Values{"csrf_token": {token}, "code": {code}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.Header.Get("Location")
+	}
+
+	b := signIn(t, srv, "a")
+	if loc := location(t, b, srv.URL, routeOverview); loc != routeVerify {
+		t.Fatalf("expected redirect to %s, got %q", routeVerify, loc)
+	}
+	if loc := verify(b, code); loc != routeVerify+"?failed=1" {
+		t.Fatalf("used code: expected the failed verification, got %q", loc)
+	}
+	next, err := totp.Code(tf.Secret, now.Add(totp.Step))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := verify(b, next); loc != routeOverview {
+		t.Fatalf("expected redirect to %s, got %q", routeOverview, loc)
+	}
+	if loc := location(t, b, srv.URL, routeOverview); loc != "" {
+		t.Fatalf("verified session redirected to %s", loc)
+	}
+
+	recovery := string(codes[0][1])
+	c := signIn(t, srv, "a")
+	if loc := verify(c, recovery); loc != routeOverview {
+		t.Fatalf("recovery code: expected redirect to %s, got %q", routeOverview, loc)
+	}
+	if loc := verify(signIn(t, srv, "a"), recovery); loc != routeVerify+"?failed=1" {
+		t.Fatalf("used recovery code: expected the failed verification, got %q", loc)
+	}
+	entries, err := h.DB.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]bool)
+	for _, e := range entries {
+		kinds[e.Kind] = true
+	}
+	if !kinds[db.AuditTwoFactorEnabled] || !kinds[db.AuditRecoveryCodeUsed] {
+		t.Fatalf("expected enabling and the recovery code in the audit log, got %+v", entries)
+	}
+
+	admin := signIn(t, srv, "b")
+	users := func(action string) url.Values {
+		return url.Values{"csrf_token": {csrfTokenOf(t, admin, srv, routeUsers)}, "action": {action}, "username": {"a"}, "required": {"true"}}
+	}
+	if status := post(t, admin, srv, routeUsers, users("reset_2fa")); status != http.StatusSeeOther {
+		t.Fatalf("reset: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	if loc := location(t, signIn(t, srv, "a"), srv.URL, routeOverview); loc != "" {
+		t.Fatalf("second factor required after the reset, redirected to %s", loc)
+	}
+
+	if status := post(t, admin, srv, routeUsers, users("require_2fa")); status != http.StatusSeeOther {
+		t.Fatalf("require: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	if loc := location(t, signIn(t, srv, "b"), srv.URL, routeOverview); loc != routeTwoFactor {
+		t.Fatalf("required: expected redirect to %s, got %q", routeTwoFactor, loc)
+	}
+	entries, err = h.DB.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Kind != db.AuditTwoFactorRequired || entries[1].Kind != db.AuditTwoFactorReset || entries[1].Username != "a" {
+		t.Fatalf("expected the reset and the requirement in the audit log, got %+v", entries[:2])
+	}
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/erikfastermann/lam/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	maxUsernameLength = 32
+)
+
+func validUsername(username string) error {
+	if username == "" || len(username) > maxUsernameLength {
+		return fmt.Errorf("username must have 1 to %d characters", maxUsernameLength)
+	}
+	for _, r := range username {
+		// LAM_USERS is separated by colons.
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) || r == ':' {
+			return fmt.Errorf("username %q contains an invalid character", username)
+		}
+	}
+	return nil
+}
+
+func validRole(role string) bool {
+	for _, r := range db.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// hashPassword returns the bcrypt hash of password.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", badRequestf("password must have at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *Handler) addAudit(kind, username string, r *http.Request, format string, a ...interface{}) error {
+	e := &db.AuditEntry{
+		Time:     time.Now(),
+		Kind:     kind,
+		Username: username,
+		IP:       remoteIP(r),
+		Message:  fmt.Sprintf(format, a...),
+	}
+	if err := h.DB.AddAudit(e); err != nil {
+		return fmt.Errorf("couldn't add %s of %s to audit log, %v", kind, username, err)
+	}
+	return nil
+}
+
+func (h *Handler) users(username string, w http.ResponseWriter, r *http.Request) error {
+	type user struct {
+		*db.User
+		TwoFactor bool
+	}
+	type usersPage struct {
+		page
+		Users            []user
+		Roles            []string
+		RequireTwoFactor bool
+	}
+
+	if r.Method == http.MethodPost {
+		if err := h.editUsers(username, r); err != nil {
+			return err
+		}
+		http.Redirect(w, r, routeUsers, http.StatusSeeOther)
+		return nil
+	}
+
+	all, err := h.DB.Users()
+	if err != nil {
+		return fmt.Errorf("couldn't read users from database, %v", err)
+	}
+	users := make([]user, 0)
+	for _, u := range all {
+		enabled, err := h.twoFactorEnabled(u.Username)
+		if err != nil {
+			return err
+		}
+		users = append(users, user{u, enabled})
+	}
+
+	required, err := h.requireTwoFactor()
+	if err != nil {
+		return err
+	}
+	data := usersPage{page: newPage(username, r), Users: users, Roles: db.Roles, RequireTwoFactor: required}
+	return h.Templates.ExecuteTemplate(w, templateUsers, data)
+}
+
+func (h *Handler) editUsers(username string, r *http.Request) error {
+	target := strings.TrimSpace(r.PostForm.Get("username"))
+	action := r.PostForm.Get("action")
+	if action == "require_2fa" {
+		required := r.PostForm.Get("required") == "true"
+		if err := h.DB.SetSetting(db.SettingRequireTwoFactor, strconv.FormatBool(required)); err != nil {
+			return fmt.Errorf("couldn't store setting %s, %v", db.SettingRequireTwoFactor, err)
+		}
+		msg := "Two-factor authentication required by %s"
+		if !required {
+			msg = "Two-factor authentication made optional by %s"
+		}
+		return h.addAudit(db.AuditTwoFactorRequired, username, r, msg, username)
+	}
+	if action == "create" {
+		if err := validUsername(target); err != nil {
+			return badRequestf("%v", err)
+		}
+		role := r.PostForm.Get("role")
+		if !validRole(role) {
+			return badRequestf("unknown role %q", role)
+		}
+		hash, err := hashPassword(r.PostForm.Get("password"))
+		if err != nil {
+			return err
+		}
+		u := &db.User{Username: target, Password: hash, Created: time.Now(), Role: role}
+		if err := h.DB.AddUser(u); err != nil {
+			if err == db.ErrUserExists {
+				return badRequestf("user %s already exists", target)
+			}
+			return fmt.Errorf("couldn't add user %s, %v", target, err)
+		}
+		return h.addAudit(db.AuditUserCreated, target, r, "Created as %s by %s", role, username)
+	}
+
+	if target == username {
+		return badRequestf("%s can't %s the own user", username, action)
+	}
+	if _, err := h.DB.User(target); err != nil {
+		if err == sql.ErrNoRows {
+			return badRequestf("user %s doesn't exist", target)
+		}
+		return fmt.Errorf("couldn't read user %s, %v", target, err)
+	}
+
+	switch action {
+	case "disable", "enable":
+		disabled := action == "disable"
+		if err := h.DB.SetUserDisabled(target, disabled); err != nil {
+			return fmt.Errorf("couldn't %s user %s, %v", action, target, err)
+		}
+		kind := db.AuditUserEnabled
+		if disabled {
+			kind = db.AuditUserDisabled
+			if err := h.Sessions.RemoveSessions(target); err != nil {
+				return fmt.Errorf("couldn't remove sessions of %s, %v", target, err)
+			}
+		}
+		return h.addAudit(kind, target, r, "%sd by %s", strings.Title(action), username)
+	case "role":
+		role := r.PostForm.Get("role")
+		if !validRole(role) {
+			return badRequestf("unknown role %q", role)
+		}
+		if err := h.DB.SetUserRole(target, role); err != nil {
+			return fmt.Errorf("couldn't change role of %s, %v", target, err)
+		}
+		return h.addAudit(db.AuditRoleChanged, target, r, "Role changed to %s by %s", role, username)
+	case "reset":
+		hash, err := hashPassword(r.PostForm.Get("password"))
+		if err != nil {
+			return err
+		}
+		if err := h.DB.SetPassword(target, hash); err != nil {
+			return fmt.Errorf("couldn't reset password of %s, %v", target, err)
+		}
+		if err := h.Sessions.RemoveSessions(target); err != nil {
+			return fmt.Errorf("couldn't remove sessions of %s, %v", target, err)
+		}
+		return h.addAudit(db.AuditPasswordReset, target, r, "Password reset by %s", username)
+	case "reset_2fa":
+		// For users who lost their device and recovery codes,
+		// they set it up again on their next sign in.
+		if err := h.DB.RemoveTwoFactor(target); err != nil {
+			if err == sql.ErrNoRows {
+				return badRequestf("%s has no two-factor authentication", target)
+			}
+			return fmt.Errorf("couldn't remove two-factor authentication of %s, %v", target, err)
+		}
+		return h.addAudit(db.AuditTwoFactorReset, target, r, "Two-factor authentication reset by %s", username)
+	case "delete":
+		if err := h.DB.RemoveUser(target); err != nil {
+			return fmt.Errorf("couldn't remove user %s, %v", target, err)
+		}
+		if err := h.Sessions.RemoveSessions(target); err != nil {
+			return fmt.Errorf("couldn't remove sessions of %s, %v", target, err)
+		}
+		if err := h.DB.RemoveTwoFactor(target); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("couldn't remove two-factor authentication of %s, %v", target, err)
+		}
+		if err := h.DB.RemoveSubscription(target); err != nil {
+			return fmt.Errorf("couldn't remove subscription of %s, %v", target, err)
+		}
+		return h.addAudit(db.AuditUserDeleted, target, r, "Deleted by %s", username)
+	default:
+		return badRequestf("unknown action %q", action)
+	}
+}
+
+func (h *Handler) changePassword(username string, w http.ResponseWriter, r *http.Request) error {
+	type changePasswordPage struct {
+		page
+		Error   string
+		Changed bool
+	}
+
+	data := changePasswordPage{page: newPage(username, r)}
+	if r.Method == http.MethodGet {
+		return h.Templates.ExecuteTemplate(w, templateChangePassword, data)
+	}
+
+	u, err := h.activeUser(username)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return unauthf("user %q doesn't exist anymore or is disabled", username)
+	}
+	ok, err := h.reauthenticate(u, r.PostForm.Get("current"), r)
+	if err != nil {
+		return err
+	}
+	password := r.PostForm.Get("password")
+	switch {
+	case !ok:
+		data.Error = "The current password is wrong or there were too many attempts, try again later."
+	case password != r.PostForm.Get("repeat"):
+		data.Error = "The new passwords don't match."
+	case len(password) < minPasswordLength:
+		data.Error = fmt.Sprintf("The new password must have at least %d characters.", minPasswordLength)
+	}
+	if data.Error != "" {
+		return h.Templates.ExecuteTemplate(w, templateChangePassword, data)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.SetPassword(username, hash); err != nil {
+		return fmt.Errorf("couldn't change password of %s, %v", username, err)
+	}
+	// Every other session might have been started with the old password.
+	sessions, err := h.Sessions.Sessions(username)
+	if err != nil {
+		return fmt.Errorf("couldn't read sessions of %s, %v", username, err)
+	}
+	current := currentSession(r).TokenHash
+	for _, s := range sessions {
+		if s.TokenHash == current {
+			continue
+		}
+		if err := h.Sessions.RemoveSession(s.TokenHash); err != nil {
+			return fmt.Errorf("couldn't remove session of %s, %v", username, err)
+		}
+	}
+	if err := h.addAudit(db.AuditPasswordChanged, username, r, "Password changed"); err != nil {
+		return err
+	}
+
+	data.Changed = true
+	return h.Templates.ExecuteTemplate(w, templateChangePassword, data)
+}
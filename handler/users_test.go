@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUsers(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+	d := h.DB
+
+	a := signIn(t, srv, "a")
+	token := csrfTokenOf(t, a, srv, routeUsers)
+	form := func(action, username, password string) url.Values {
+		return url.Values{"csrf_token": {token}, "action": {action}, "username": {username}, "password": {password}, "role": {db.RoleViewer}}
+	}
+	for _, c := range []struct {
+		action, username, password string
+		want                       int
+	}{
+		{"create", "c", "short", http.StatusBadRequest},
+		{"create", "c d", "password", http.StatusBadRequest},
+		{"create", "c", "password", http.StatusSeeOther},
+		{"create", "c", "password", http.StatusBadRequest},
+		{"disable", "a", "", http.StatusBadRequest},
+		{"delete", "unknown", "", http.StatusBadRequest},
+	} {
+		if status := post(t, a, srv, routeUsers, form(c.action, c.username, c.password)); status != c.want {
+			t.Fatalf("%s %q: expected status %d, got %d", c.action, c.username, c.want, status)
+		}
+	}
+
+	res, err := noRedirect.PostForm(srv.URL+routeLogin, url.Values{"username": {"c"}, "password": {"password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if loc := res.Header.Get("Location"); loc != routeOverview {
+		t.Fatalf("created user: expected redirect to %s, got %q", routeOverview, loc)
+	}
+
+	b := signIn(t, srv, "b")
+	if status := post(t, a, srv, routeUsers, form("disable", "b", "")); status != http.StatusSeeOther {
+		t.Fatalf("disable: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	if loc := location(t, b, srv.URL, routeOverview); loc != routeLogin {
+		t.Fatalf("disabled user: expected redirect to %s, got %q", routeLogin, loc)
+	}
+	res, err = noRedirect.PostForm(srv.URL+routeLogin, url.Values{"username": {"b"}, "password": {"pw"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(res.Cookies()) != 0 {
+		t.Fatal("disabled user signed in")
+	}
+
+	if status := post(t, a, srv, routeUsers, form("delete", "c", "")); status != http.StatusSeeOther {
+		t.Fatalf("delete: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	users, err := d.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || !users[1].Disabled {
+		t.Fatalf("expected a and the disabled b, got %+v", users)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+
+	a := signIn(t, srv, "a")
+	other := signIn(t, srv, "a")
+	token := csrfTokenOf(t, a, srv, routeChangePassword)
+	form := func(current, password, repeat string) url.Values {
+		return url.Values{"csrf_token": {token}, "current": {current}, "password": {password}, "repeat": {repeat}}
+	}
+	if status := post(t, a, srv, routeChangePassword, form("wrong", "password", "password")); status != http.StatusOK {
+		t.Fatalf("wrong password: expected status %d, got %d", http.StatusOK, status)
+	}
+	if loc := location(t, other, srv.URL, routeOverview); loc != "" {
+		t.Fatalf("session removed after a failed change, redirected to %s", loc)
+	}
+	for i := 1; i < freeFailures; i++ {
+		post(t, a, srv, routeChangePassword, form("wrong", "password", "password"))
+	}
+	if status := post(t, a, srv, routeChangePassword, form("pw", "throttled", "throttled")); status != http.StatusOK {
+		t.Fatalf("throttled: expected status %d, got %d", http.StatusOK, status)
+	}
+	if loc := location(t, other, srv.URL, routeOverview); loc != "" {
+		t.Fatalf("password changed while throttled, other session redirected to %s", loc)
+	}
+	h.userThrottle.reset("a")
+	h.ipThrottle.reset("127.0.0.1")
+	if status := post(t, a, srv, routeChangePassword, form("pw", "password", "password")); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if loc := location(t, other, srv.URL, routeOverview); loc != routeLogin {
+		t.Fatalf("other session: expected redirect to %s, got %q", routeLogin, loc)
+	}
+	if loc := location(t, a, srv.URL, routeOverview); loc != "" {
+		t.Fatalf("current session redirected to %s", loc)
+	}
+	res, err := noRedirect.PostForm(srv.URL+routeLogin, url.Values{"username": {"a"}, "password": {"password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(res.Cookies()) == 0 {
+		t.Fatal("couldn't sign in with the new password")
+	}
+}
+
+func TestRoles(t *testing.T) {
+	srv, h, stop := testServer(t)
+	defer stop()
+	d := h.DB
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for username, role := range map[string]string{"v": db.RoleViewer, "e": db.RoleEditor} {
+		u := &db.User{Username: username, Password: string(hash), Created: time.Now(), Role: role}
+		if err := d.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acc := &db.Account{Region: "euw", IGN: "player0", Password: "secret"}
+	if err := d.AddAccount(acc); err != nil {
+		t.Fatal(err)
+	}
+	id := "/" + strconv.Itoa(acc.ID)
+
+	v, e := signIn(t, srv, "v"), signIn(t, srv, "e")
+	for _, c := range []struct {
+		name string
+		c    *http.Client
+		p    string
+		want int
+	}{
+		{"v", v, routeOverview, http.StatusOK},
+		{"v", v, routeEdit + id, http.StatusForbidden},
+		{"v", v, routeAdd, http.StatusForbidden},
+		{"v", v, routeUsers, http.StatusForbidden},
+		{"v", v, routeAudit, http.StatusForbidden},
+		{"v", v, routePassword + id, http.StatusForbidden},
+		{"e", e, routeEdit + id, http.StatusOK},
+		{"e", e, routePassword + id, http.StatusOK},
+		{"e", e, routeRemove + id, http.StatusForbidden},
+		{"e", e, routeUsers, http.StatusForbidden},
+	} {
+		if status := get(t, c.c, srv, c.p); status != c.want {
+			t.Errorf("%s: GET %s: expected status %d, got %d", c.name, c.p, c.want, status)
+		}
+	}
+
+	token := csrfTokenOf(t, v, srv, routeOverview)
+	checkout := func(c *http.Client, token, action string) int {
+		return post(t, c, srv, routeCheckout+id, url.Values{"csrf_token": {token}, "action": {action}})
+	}
+	if status := checkout(v, token, ""); status != http.StatusSeeOther {
+		t.Fatalf("check out: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+	if status := get(t, v, srv, routePassword+id); status != http.StatusOK {
+		t.Fatalf("checked out: expected status %d for the password, got %d", http.StatusOK, status)
+	}
+	if status := checkout(e, csrfTokenOf(t, e, srv, routeOverview), ""); status != http.StatusBadRequest {
+		t.Fatalf("check out of a checked out account: expected status %d, got %d", http.StatusBadRequest, status)
+	}
+	if status := checkout(v, token, "return"); status != http.StatusSeeOther {
+		t.Fatalf("return: expected status %d, got %d", http.StatusSeeOther, status)
+	}
+
+	events, err := d.Events(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkouts := 0
+	for _, ev := range events {
+		if ev.Kind == db.EventCheckout {
+			checkouts++
+		}
+	}
+	if checkouts != 2 {
+		t.Fatalf("expected the check out and return in the history, got %+v", events)
+	}
+	if got, err := d.Account(acc.ID); err != nil || got.User != "" {
+		t.Fatalf("expected the account to be returned, got %+v (err: %v)", got, err)
+	}
+	// Pages viewers can open don't link to pages they can't open.
+	for _, p := range []string{routeOverview, routeNotifications} {
+		res, err := v.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range []string{routeEdit, routeAdd, routeRemove, routeWebhooks, routeAudit, routeUsers} {
+			if bytes.Contains(body, []byte(`href="`+link)) {
+				t.Errorf("%s links to %s for a viewer", p, link)
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/erikfastermann/lam/notify"
+)
+
+func (h *Handler) webhooks(username string, w http.ResponseWriter, r *http.Request) error {
+	type webhooksPage struct {
+		page
+		Configured bool
+		Deliveries []notify.Delivery
+	}
+
+	data := webhooksPage{page: newPage(username, r)}
+	if h.Webhooks != nil {
+		data.Configured = len(h.Webhooks.URLs) > 0
+		data.Deliveries = h.Webhooks.Deliveries()
+	}
+	return h.Templates.ExecuteTemplate(w, templateWebhooks, data)
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/erikfastermann/httpwrap"
+	"github.com/erikfastermann/lam/ban"
 	"github.com/erikfastermann/lam/db"
 	"github.com/erikfastermann/lam/elo"
 	"github.com/erikfastermann/lam/handler"
+	"github.com/erikfastermann/lam/notify"
 )
 
 func main() {
@@ -21,14 +30,14 @@ func main() {
 	}
 }
 
-func run() error {
+func run() (err error) {
 	type entry struct {
 		name string
 		dest *string
 	}
 
 	var addr, https, domain, cert, key string
-	var users, dbDir string
+	var dbDir string
 	var tmplt string
 	for _, e := range []entry{
 		{"ADDRESS", &addr},
@@ -37,7 +46,6 @@ func run() error {
 		{"CERT", &cert},
 		{"KEY", &key},
 
-		{"USERS", &users},
 		{"DB_DIR", &dbDir},
 
 		{"TEMPLATE_GLOB", &tmplt},
@@ -49,54 +57,218 @@ func run() error {
 		}
 	}
 
-	split := strings.Split(users, ":")
-	if len(split)%2 != 0 {
-		return fmt.Errorf("not every user has a password set")
-	}
-	u := make([]*handler.User, 0)
-	for i := 0; i < len(split); i += 2 {
-		u = append(u, &handler.User{
-			Username: split[i],
-			Password: split[i+1],
-		})
-	}
-
-	h := &handler.Handler{
-		Users: u,
-	}
-
-	var err error
+	h := new(handler.Handler)
 	h.DB, err = db.Init(dbDir)
 	if err != nil {
 		return err
 	}
-	defer h.DB.Close()
+	defer func() {
+		if cErr := h.DB.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+	if err := importUsers(h.DB, os.Getenv("LAM_USERS")); err != nil {
+		return err
+	}
 
 	h.Templates, err = template.ParseGlob(tmplt)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		duration := 24 * time.Hour
+	cacheDir := os.Getenv("LAM_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(dbDir, "cache")
+	}
+	cacheTTL := time.Hour
+	if ttl := os.Getenv("LAM_CACHE_TTL"); ttl != "" {
+		cacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return fmt.Errorf("env LAM_CACHE_TTL: %v", err)
+		}
+	}
+
+	if require := os.Getenv("LAM_REQUIRE_2FA"); require != "" {
+		if err := importRequireTwoFactor(h.DB, require); err != nil {
+			return err
+		}
+	}
+
+	h.Events = &notify.Dispatcher{DB: h.DB}
+	if urls := strings.Fields(os.Getenv("LAM_WEBHOOK_URLS")); len(urls) > 0 {
+		h.Webhooks = &notify.Webhooks{URLs: urls, Secret: os.Getenv("LAM_WEBHOOK_SECRET")}
+		h.Events.Notifiers = append(h.Events.Notifiers, h.Webhooks)
+	}
+	if host := os.Getenv("LAM_SMTP_HOST"); host != "" {
+		h.Email, err = emailFromEnv(host, h.DB)
+		if err != nil {
+			return err
+		}
+		h.Events.Notifiers = append(h.Events.Notifiers, h.Email)
+	}
+
+	h.Updater = &elo.Updater{
+		DB:     h.DB,
+		Events: h.Events,
+		LeagueOfGraphs: &elo.LeagueOfGraphs{
+			Client: &http.Client{Timeout: 10 * time.Second},
+			Cache:  &elo.Cache{Dir: cacheDir, TTL: cacheTTL},
+		},
+	}
+	if key := os.Getenv("LAM_RIOT_API_KEY"); key != "" {
+		h.Updater.Riot = elo.NewRiotAPI(key)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	start := func(run func(context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
+	if h.Webhooks != nil {
+		start(h.Webhooks.Run)
+	}
+	if h.Email != nil {
+		start(h.Email.Run)
+	}
+	start((&ban.Scheduler{DB: h.DB, Events: h.Events}).Run)
+	start(h.Updater.Run)
+	start(func(ctx context.Context) {
+		duration := time.Hour
 		l := log.New(os.Stderr, "ERROR ", log.LstdFlags)
 		for {
-			if err := elo.UpdateAll(h.DB); err != nil {
+			if err := h.Updater.UpdateAll(ctx); err != nil && ctx.Err() == nil {
 				l.Printf("elo: %v, retrying in %s", err, duration)
 			}
-			time.Sleep(duration)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(duration):
+			}
 		}
-	}()
+	})
 
+	redirect := newServer(addr, httpwrap.Log(http.RedirectHandler(domain, http.StatusMovedPermanently)))
+	srv := newServer(https, httpwrap.Log(httpwrap.HandleError(h)))
+	errc := make(chan error, 2)
 	go func() {
-		srv := newServer(addr, httpwrap.Log(http.RedirectHandler(domain, http.StatusMovedPermanently)))
-		log.Fatal(srv.ListenAndServe())
+		errc <- redirect.ListenAndServe()
+	}()
+	go func() {
+		errc <- srv.ListenAndServeTLS(cert, key)
 	}()
-
-	srv := newServer(https, httpwrap.Log(httpwrap.HandleError(h)))
 	log.Printf("server: listening on address %s (https)", https)
 	log.Printf("server: redirecting http (address: %s) to %s", addr, domain)
-	return srv.ListenAndServeTLS(cert, key)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		log.Printf("server: received %s, shutting down", s)
+	case err = <-errc:
+	}
+
+	cancel()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	for _, s := range []*http.Server{redirect, srv} {
+		if sErr := s.Shutdown(shutdownCtx); sErr != nil && err == nil {
+			err = sErr
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// importUsers adds the users of LAM_USERS (user1:bcrypt1:user2:bcrypt2)
+// as admins, if there are no users in the database yet.
+// Afterwards users are managed on /users and LAM_USERS is ignored.
+func importUsers(d *db.DB, users string) error {
+	all, err := d.Users()
+	if err != nil {
+		return err
+	}
+	if len(all) > 0 {
+		if users != "" {
+			log.Printf("users: env LAM_USERS is ignored, the database already has users")
+		}
+		return nil
+	}
+	if users == "" {
+		return fmt.Errorf("no users in the database and env LAM_USERS is empty")
+	}
+
+	split := strings.Split(users, ":")
+	if len(split)%2 != 0 {
+		return fmt.Errorf("env LAM_USERS: not every user has a password set")
+	}
+	for i := 0; i < len(split); i += 2 {
+		u := &db.User{Username: split[i], Password: split[i+1], Created: time.Now(), Role: db.RoleAdmin}
+		if err := d.AddUser(u); err != nil {
+			return fmt.Errorf("couldn't import user %s, %v", u.Username, err)
+		}
+	}
+	log.Printf("users: imported %d users from env LAM_USERS", len(split)/2)
+	return nil
+}
+
+// importRequireTwoFactor stores LAM_REQUIRE_2FA as the initial setting.
+// Afterwards it is changed on /users and LAM_REQUIRE_2FA is ignored.
+func importRequireTwoFactor(d *db.DB, require string) error {
+	required, err := strconv.ParseBool(require)
+	if err != nil {
+		return fmt.Errorf("env LAM_REQUIRE_2FA: %v", err)
+	}
+	_, err = d.Setting(db.SettingRequireTwoFactor)
+	if err == nil {
+		log.Printf("settings: env LAM_REQUIRE_2FA is ignored, the setting is already stored")
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return d.SetSetting(db.SettingRequireTwoFactor, strconv.FormatBool(required))
+}
+
+func emailFromEnv(host string, d *db.DB) (*notify.Email, error) {
+	s := &notify.SMTP{
+		Host:     host,
+		Port:     587,
+		Username: os.Getenv("LAM_SMTP_USERNAME"),
+		Password: os.Getenv("LAM_SMTP_PASSWORD"),
+		From:     os.Getenv("LAM_SMTP_FROM"),
+		TLS:      notify.TLSStartTLS,
+	}
+	if s.From == "" {
+		return nil, fmt.Errorf("env LAM_SMTP_FROM is empty")
+	}
+	if port := os.Getenv("LAM_SMTP_PORT"); port != "" {
+		var err error
+		if s.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("env LAM_SMTP_PORT: %v", err)
+		}
+	}
+	if mode := os.Getenv("LAM_SMTP_TLS"); mode != "" {
+		if mode != notify.TLSStartTLS && mode != notify.TLSImplicit && mode != notify.TLSNone {
+			return nil, fmt.Errorf("env LAM_SMTP_TLS: unknown mode %q", mode)
+		}
+		s.TLS = mode
+	}
+
+	m := &notify.Email{SMTP: s, DB: d, DigestHour: 8}
+	if hour := os.Getenv("LAM_DIGEST_HOUR"); hour != "" {
+		var err error
+		m.DigestHour, err = strconv.Atoi(hour)
+		if err != nil || m.DigestHour < 0 || m.DigestHour > 23 {
+			return nil, fmt.Errorf("env LAM_DIGEST_HOUR: invalid hour %q", hour)
+		}
+	}
+	return m, nil
 }
 
 func newServer(addr string, h http.Handler) *http.Server {
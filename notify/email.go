@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+// Topics of db.Subscription.
+const (
+	TopicDigest          = "digest"
+	TopicBanExpiring     = "ban_expiring"
+	TopicPasswordChanged = "password_changed"
+	TopicRankDropped     = "rank_dropped"
+)
+
+type Topic struct {
+	Name, Description string
+}
+
+var Topics = []Topic{
+	{TopicDigest, "Daily digest of every event"},
+	{TopicBanExpiring, "A ban expires tomorrow"},
+	{TopicPasswordChanged, "A password was marked changed"},
+	{TopicRankDropped, "A rank dropped"},
+}
+
+// topic returns the topic of an alert about an event of kind,
+// or an empty string if there is none.
+func topic(kind string) string {
+	switch kind {
+	case db.EventBanExpiring:
+		return TopicBanExpiring
+	case db.EventPasswordChanged:
+		return TopicPasswordChanged
+	case db.EventDemotion, db.EventDecay:
+		return TopicRankDropped
+	default:
+		return ""
+	}
+}
+
+// Email sends alerts to the subscribers of their topic
+// and a daily digest. Mails are sent once Run is called.
+type Email struct {
+	SMTP *SMTP
+	DB   *db.DB
+	// DigestHour is the local hour the digest is sent at.
+	DigestHour int
+	// Logger is used for failed mails, defaults to stderr.
+	Logger *log.Logger
+
+	once  sync.Once
+	queue chan *mail
+}
+
+type mail struct {
+	topic, subject, body string
+}
+
+func (m *Email) init() {
+	m.queue = make(chan *mail, queueLength)
+	if m.Logger == nil {
+		m.Logger = log.New(os.Stderr, "ERROR ", log.LstdFlags)
+	}
+}
+
+// Notify queues an alert if e belongs to a topic.
+func (m *Email) Notify(e *db.Event, acc *db.Account) error {
+	m.once.Do(m.init)
+
+	t := topic(e.Kind)
+	if t == "" {
+		return nil
+	}
+	name := "deleted account"
+	if acc != nil {
+		name = fmt.Sprintf("%s (%s)", acc.RiotID(), acc.Region)
+	}
+	msg := &mail{
+		topic:   t,
+		subject: fmt.Sprintf("[LAM] %s: %s", name, e.Message),
+		body:    fmt.Sprintf("%s\n\n%s: %s\n", e.Time.Local().Format(mailTimeFormat), name, e.Message),
+	}
+	select {
+	case m.queue <- msg:
+		return nil
+	default:
+		return fmt.Errorf("email: queue is full, dropped %q", msg.subject)
+	}
+}
+
+const mailTimeFormat = "2 Jan 2006 15:04"
+
+// Run sends queued mails and the digest until ctx is done.
+func (m *Email) Run(ctx context.Context) {
+	m.once.Do(m.init)
+
+	next := nextDigest(time.Now(), m.DigestHour)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.queue:
+			if err := m.send(msg); err != nil {
+				m.Logger.Printf("email: %v", err)
+			}
+		case <-timer.C:
+			if err := m.digest(next.Add(-24*time.Hour), next); err != nil {
+				m.Logger.Printf("email: digest: %v", err)
+			}
+			next = nextDigest(time.Now(), m.DigestHour)
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// nextDigest returns the first time after now at hour.
+func nextDigest(now time.Time, hour int) time.Time {
+	y, mo, d := now.Date()
+	t := time.Date(y, mo, d, hour, 0, 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func (m *Email) send(msg *mail) error {
+	to, err := m.subscribers(msg.topic)
+	if err != nil || len(to) == 0 {
+		return err
+	}
+	return m.SMTP.Send(to, msg.subject, msg.body)
+}
+
+func (m *Email) subscribers(t string) ([]string, error) {
+	subs, err := m.DB.Subscriptions()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read subscriptions from database, %v", err)
+	}
+	to := make([]string, 0)
+	for _, s := range subs {
+		if s.Email != "" && s.Has(t) {
+			to = append(to, s.Email)
+		}
+	}
+	return to, nil
+}
+
+// digest mails the events between since and until,
+// nothing is sent if there are none.
+func (m *Email) digest(since, until time.Time) error {
+	to, err := m.subscribers(TopicDigest)
+	if err != nil || len(to) == 0 {
+		return err
+	}
+
+	events, err := m.DB.AllEvents()
+	if err != nil {
+		return fmt.Errorf("couldn't read events from database, %v", err)
+	}
+	accs, err := m.DB.Accounts()
+	if err != nil {
+		return fmt.Errorf("couldn't read accounts from database, %v", err)
+	}
+	names := make(map[int]string)
+	for _, acc := range accs {
+		names[acc.ID] = acc.RiotID()
+	}
+
+	var body strings.Builder
+	n := 0
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.Time.Before(since) || !e.Time.Before(until) {
+			continue
+		}
+		n++
+		name := names[e.AccountID]
+		if name == "" {
+			name = "deleted account"
+		}
+		fmt.Fprintf(&body, "%s [%s] %s: %s\n", e.Time.Local().Format(mailTimeFormat), e.Kind, name, e.Message)
+	}
+	if n == 0 {
+		return nil
+	}
+	subject := fmt.Sprintf("[LAM] Digest: %d events since %s", n, since.Local().Format(mailTimeFormat))
+	return m.SMTP.Send(to, subject, body.String())
+}
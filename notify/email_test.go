@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+type message struct {
+	to, data string
+}
+
+// fakeSMTP accepts every mail and sends it to the returned channel.
+func fakeSMTP(t *testing.T) (*SMTP, chan message) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make(chan message, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, msgs)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &SMTP{Host: "127.0.0.1", Port: addr.Port, From: "lam@example.com", TLS: TLSNone}
+	return s, msgs
+}
+
+func serveSMTP(conn net.Conn, msgs chan<- message) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+
+	reply("220 localhost")
+	var msg message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.data = data.String()
+			msgs <- msg
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, s := range []*db.Subscription{
+		{Username: "a", Email: "a@example.com", Topics: []string{TopicRankDropped, TopicDigest}},
+		{Username: "b", Email: "b@example.com", Topics: []string{TopicBanExpiring}},
+	} {
+		if err := d.SetSubscription(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, msgs := fakeSMTP(t)
+	m := &Email{SMTP: s, DB: d}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	acc := &db.Account{ID: 1, Region: "euw", IGN: "player", TagLine: "EUW"}
+	now := time.Now()
+	for _, e := range []*db.Event{
+		{AccountID: 1, Time: now, Kind: db.EventPromotion, Message: "Solo/Duo: Gold II → Gold I"},
+		{AccountID: 1, Time: now, Kind: db.EventDemotion, Message: "Solo/Duo: Gold I → Gold II"},
+	} {
+		if err := d.AddEvent(e); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Notify(e, acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case msg := <-msgs:
+		if msg.to != "a@example.com" || !strings.Contains(msg.data, "Gold I =E2=86=92 Gold II") {
+			t.Fatalf("unexpected mail to %s:\n%s", msg.to, msg.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert wasn't sent")
+	}
+
+	if err := m.digest(now.Add(-time.Hour), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.to != "a@example.com" || !strings.Contains(msg.data, "2 events") {
+			t.Fatalf("unexpected digest to %s:\n%s", msg.to, msg.data)
+		}
+	default:
+		t.Fatal("digest wasn't sent")
+	}
+	select {
+	case msg := <-msgs:
+		t.Fatalf("unexpected mail to %s:\n%s", msg.to, msg.data)
+	default:
+	}
+}
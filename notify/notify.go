@@ -0,0 +1,53 @@
+// Package notify records account events and delivers them
+// to channels outside of the app.
+package notify
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+// Notifier delivers events, e.g. to a chat or by email.
+// Notify shouldn't block for long, slow deliveries belong in the background.
+type Notifier interface {
+	// acc is the account the event belongs to, it may be nil.
+	Notify(e *db.Event, acc *db.Account) error
+}
+
+// Dispatcher stores events in DB and passes them on to every Notifier.
+type Dispatcher struct {
+	DB        *db.DB
+	Notifiers []Notifier
+	// Logger is used for failed deliveries, defaults to stderr.
+	Logger *log.Logger
+
+	once sync.Once
+}
+
+// Emit stores e. Failed deliveries are only logged,
+// an error is returned if e couldn't be stored.
+func (d *Dispatcher) Emit(e *db.Event, acc *db.Account) error {
+	if err := d.DB.AddEvent(e); err != nil {
+		return err
+	}
+	for _, n := range d.Notifiers {
+		if err := n.Notify(e, acc); err != nil {
+			d.logger().Printf("notify: event %d (%s): %v", e.ID, e.Kind, err)
+		}
+	}
+	return nil
+}
+
+// logger returns Logger, Emit is called concurrently,
+// so the default is set only once.
+func (d *Dispatcher) logger() *log.Logger {
+	d.once.Do(func() {
+		if d.Logger == nil {
+			d.Logger = log.New(os.Stderr, "ERROR ", log.LstdFlags)
+		}
+	})
+	return d.Logger
+}
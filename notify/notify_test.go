@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(e *db.Event, acc *db.Account) error {
+	return errors.New("unavailable")
+}
+
+func TestEmitConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lam-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// The default Logger writes the failed deliveries to stderr.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stderr := os.Stderr
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	const n = 8
+	dp := &Dispatcher{DB: d, Notifiers: []Notifier{failingNotifier{}}}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := &db.Event{AccountID: i, Time: time.Now(), Kind: db.EventPromotion}
+			if err := dp.Emit(e, nil); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	events, err := d.AllEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != n {
+		t.Fatalf("expected %d events despite the failed deliveries, got %d", n, len(events))
+	}
+}
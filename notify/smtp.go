@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// TLS modes of SMTP.
+const (
+	// TLSStartTLS upgrades the connection and fails if the server doesn't support it.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone sends everything in plain text, net/smtp refuses
+	// to authenticate like that unless the server runs on localhost.
+	TLSNone = "none"
+)
+
+// SMTP is the configuration of a mail server.
+type SMTP struct {
+	Host string
+	Port int
+	// Username and Password are used for PLAIN auth if Username isn't empty.
+	Username, Password string
+	From               string
+	// TLS defaults to TLSStartTLS.
+	TLS string
+}
+
+const smtpTimeout = 30 * time.Second
+
+// Send mails a plain text message to every address in to,
+// each recipient gets a separate mail.
+func (s *SMTP) Send(to []string, subject, body string) error {
+	for _, addr := range to {
+		if err := s.send(addr, subject, body); err != nil {
+			return fmt.Errorf("smtp: sending to %s failed, %v", addr, err)
+		}
+	}
+	return nil
+}
+
+func (s *SMTP) send(to, subject, body string) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var conn net.Conn
+	var err error
+	if s.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.TLS == "" || s.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(to, subject, body)); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *SMTP) message(to, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(body))
+	w.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,265 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+// SignatureHeader holds the HMAC-SHA256 of the request body
+// in the form sha256=<hex>, keyed with the webhook secret.
+const SignatureHeader = "X-LAM-Signature"
+
+const (
+	queueLength = 100
+	logLength   = 200
+)
+
+// Webhooks posts every event as JSON to each of URLs.
+// Deliveries run in the background once Run is called,
+// failed attempts are retried with exponential backoff.
+//
+// The payload contains a content field with a short summary,
+// so the URL of a Discord compatible chat can be used directly.
+type Webhooks struct {
+	URLs []string
+	// Secret signs the body, the signature is omitted if it's empty.
+	Secret string
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+	// Attempts defaults to 5.
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles after each one.
+	// Defaults to 10 seconds.
+	Backoff time.Duration
+
+	once  sync.Once
+	queue chan *delivery
+
+	mu  sync.Mutex
+	log []Delivery
+}
+
+// Delivery is a single attempt to deliver an event.
+type Delivery struct {
+	Time    time.Time
+	URL     string
+	EventID int
+	Kind    string
+	Attempt int
+	// Status is the response status or the error of the attempt.
+	Status string
+	OK     bool
+}
+
+type delivery struct {
+	url  string
+	e    *db.Event
+	body []byte
+}
+
+type payload struct {
+	ID      int       `json:"id"`
+	Kind    string    `json:"kind"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Account *account  `json:"account,omitempty"`
+	Content string    `json:"content"`
+}
+
+// account leaves out the credentials,
+// they never leave the app.
+type account struct {
+	ID     int    `json:"id"`
+	Region string `json:"region"`
+	Tag    string `json:"tag"`
+	RiotID string `json:"riot_id"`
+	User   string `json:"user"`
+}
+
+func (wh *Webhooks) init() {
+	wh.queue = make(chan *delivery, queueLength)
+	if wh.Client == nil {
+		wh.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if wh.Attempts <= 0 {
+		wh.Attempts = 5
+	}
+	if wh.Backoff <= 0 {
+		wh.Backoff = 10 * time.Second
+	}
+}
+
+// Notify queues a delivery to every URL.
+func (wh *Webhooks) Notify(e *db.Event, acc *db.Account) error {
+	wh.once.Do(wh.init)
+
+	p := payload{
+		ID:      e.ID,
+		Kind:    e.Kind,
+		Time:    e.Time,
+		Message: e.Message,
+		Content: fmt.Sprintf("[%s] %s", e.Kind, e.Message),
+	}
+	if acc != nil {
+		p.Account = &account{
+			ID:     acc.ID,
+			Region: acc.Region,
+			Tag:    acc.Tag,
+			RiotID: acc.RiotID(),
+			User:   acc.User,
+		}
+		p.Content = fmt.Sprintf("[%s] **%s** (%s): %s", e.Kind, acc.RiotID(), acc.Region, e.Message)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range wh.URLs {
+		select {
+		case wh.queue <- &delivery{url: u, e: e, body: body}:
+		default:
+			return fmt.Errorf("webhook: queue is full, dropped delivery to %s", redact(u))
+		}
+	}
+	return nil
+}
+
+// Run delivers queued events until ctx is done
+// and waits for running deliveries before returning.
+func (wh *Webhooks) Run(ctx context.Context) {
+	wh.once.Do(wh.init)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-wh.queue:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wh.deliver(ctx, d)
+			}()
+		}
+	}
+}
+
+// Deliveries returns the most recent attempts, newest first.
+func (wh *Webhooks) Deliveries() []Delivery {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	log := make([]Delivery, len(wh.log))
+	for i, d := range wh.log {
+		log[len(log)-1-i] = d
+	}
+	return log
+}
+
+func (wh *Webhooks) deliver(ctx context.Context, d *delivery) {
+	wait := wh.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := wh.post(ctx, d.url, d.e.Kind, d.e.ID, d.body)
+
+		entry := Delivery{
+			Time:    time.Now(),
+			URL:     redact(d.url),
+			EventID: d.e.ID,
+			Kind:    d.e.Kind,
+			Attempt: attempt,
+			Status:  "delivered",
+			OK:      err == nil,
+		}
+		if err != nil {
+			entry.Status = err.Error()
+		}
+		wh.record(entry)
+
+		if !retry || attempt >= wh.Attempts {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// post reports whether a failed request should be retried.
+func (wh *Webhooks) post(ctx context.Context, u, kind string, id int, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lam-webhook")
+	req.Header.Set("X-LAM-Event", kind)
+	req.Header.Set("X-LAM-Delivery", strconv.Itoa(id))
+	if wh.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(wh.Secret, body))
+	}
+
+	res, err := wh.Client.Do(req)
+	if err != nil {
+		// The error of the client repeats the URL.
+		if uErr, ok := err.(*url.Error); ok {
+			err = uErr.Err
+		}
+		return true, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, errors.New(res.Status)
+	default:
+		return false, errors.New(res.Status)
+	}
+}
+
+func (wh *Webhooks) record(d Delivery) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.log = append(wh.log, d)
+	if len(wh.log) > logLength {
+		wh.log = append(wh.log[:0], wh.log[len(wh.log)-logLength:]...)
+	}
+}
+
+// Sign returns the value of SignatureHeader for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// redact removes the path of u, chat webhooks keep their token there.
+func redact(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "invalid URL"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/lam/db"
+)
+
+func TestWebhooks(t *testing.T) {
+	const secret = "s3cret"
+
+	var mu sync.Mutex
+	attempts := 0
+	received := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := r.Header.Get(SignatureHeader), Sign(secret, body); got != want {
+			t.Errorf("expected signature %s, got %s", want, got)
+		}
+
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+
+		var p map[string]interface{}
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Error(err)
+		}
+		received <- p
+	}))
+	defer srv.Close()
+
+	wh := &Webhooks{URLs: []string{srv.URL + "/hook/token"}, Secret: secret, Backoff: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		wh.Run(ctx)
+		close(done)
+	}()
+
+	e := &db.Event{ID: 3, AccountID: 1, Time: time.Now(), Kind: db.EventPromotion, Message: "Solo/Duo: Gold II → Gold I"}
+	acc := &db.Account{ID: 1, Region: "euw", IGN: "player", TagLine: "EUW", Username: "login", Password: "hunter2"}
+	if err := wh.Notify(e, acc); err != nil {
+		t.Fatal(err)
+	}
+
+	var p map[string]interface{}
+	select {
+	case p = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't delivered")
+	}
+	// The attempt is logged after the response arrived.
+	for i := 0; len(wh.Deliveries()) < 2 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if p["kind"] != db.EventPromotion || p["content"] == "" {
+		t.Fatalf("unexpected payload %v", p)
+	}
+	b, _ := json.Marshal(p)
+	if strings.Contains(string(b), "hunter2") || strings.Contains(string(b), "login") {
+		t.Fatalf("payload contains credentials: %s", b)
+	}
+
+	log := wh.Deliveries()
+	if len(log) != 2 {
+		t.Fatalf("expected 2 attempts, got %+v", log)
+	}
+	if !log[0].OK || log[0].Attempt != 2 || log[1].OK || log[1].Status != "503 Service Unavailable" {
+		t.Fatalf("unexpected delivery log %+v", log)
+	}
+	if strings.Contains(log[0].URL, "token") {
+		t.Fatalf("delivery log shows the path of the URL: %s", log[0].URL)
+	}
+}
@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238, Appendix B.
+func TestRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, v := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		got := code(key, Counter(time.Unix(v.unix, 0)), 8)
+		if got != v.code {
+			t.Errorf("T=%d: expected %s, got %s", v.unix, v.code, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1111111111, 0)
+	c, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != Digits {
+		t.Fatalf("expected %d digits, got %q", Digits, c)
+	}
+
+	for _, d := range []time.Duration{-Step, 0, Step} {
+		counter, ok := Verify(secret, c, now.Add(d))
+		if !ok {
+			t.Errorf("code rejected %v later", d)
+		}
+		if counter != Counter(now) {
+			t.Errorf("expected counter %d, got %d", Counter(now), counter)
+		}
+	}
+	for _, d := range []time.Duration{-2 * Step, 2 * Step} {
+		if _, ok := Verify(secret, c, now.Add(d)); ok {
+			t.Errorf("code accepted %v later", d)
+		}
+	}
+	if _, ok := Verify(secret, c[:3]+" "+c[3:], now); !ok {
+		t.Error("spaces aren't ignored")
+	}
+	if _, ok := Verify(secret, "", now); ok {
+		t.Error("empty code accepted")
+	}
+}
+
+func TestURL(t *testing.T) {
+	got := URL("LAM", "me", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/LAM:me?issuer=LAM&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}